@@ -0,0 +1,234 @@
+package gproc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	ActorIdleTimeout      = time.Duration(time.Minute)           // 默认的actor空闲超时
+	ActorMinCheckInterval = time.Duration(10 * time.Millisecond) // 最小的空闲检查间隔
+)
+
+// 虚拟actor接口，actor由ActorHost按需创建，所有方法都在同一个worker goroutine中调用
+// 方法panic时actor被丢弃，不调用OnDeactivate，同一个worker上的其他actor不受影响
+type IActor interface {
+	// 激活，创建后处理第一条消息之前调用，一般在这里加载持久化的状态
+	OnActivate(kind string, key interface{})
+	// 处理消息
+	Receive(sender ISender, msgId uint32, args interface{})
+	// 失活，空闲超时或者宿主关闭时调用，一般在这里持久化状态
+	OnDeactivate()
+}
+
+// actor标识
+type actorId struct {
+	kind string
+	key  interface{}
+}
+
+// actor实例
+type actorEntry struct {
+	actor      IActor
+	lastActive time.Time
+}
+
+// 虚拟actor宿主，往(kind, key)发送消息时按需创建actor，空闲超时后失活，再有消息时重新激活
+// actor按(kind, key)的哈希固定在某个worker上运行，保证同一个actor同一时间只在一个goroutine中处理消息
+type ActorHost struct {
+	locker      sync.RWMutex
+	factories   map[string]func(key interface{}) IActor
+	workers     []*actorWorker
+	idleTimeout time.Duration
+	wg          sync.WaitGroup
+	closed      bool
+}
+
+// 创建actor宿主，workerNum是共享的goroutine数量
+func NewActorHost(workerNum int, idleTimeout time.Duration) *ActorHost {
+	host := &ActorHost{}
+	host.Init(workerNum, idleTimeout)
+	return host
+}
+
+// 初始化
+func (h *ActorHost) Init(workerNum int, idleTimeout time.Duration) {
+	if workerNum <= 0 {
+		workerNum = 1
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = ActorIdleTimeout
+	}
+	h.idleTimeout = idleTimeout
+	h.factories = make(map[string]func(key interface{}) IActor)
+	h.workers = make([]*actorWorker, workerNum)
+	for i := 0; i < workerNum; i++ {
+		h.workers[i] = newActorWorker(h)
+	}
+}
+
+// 注册actor类型的工厂
+func (h *ActorHost) RegisterKind(kind string, factory func(key interface{}) IActor) {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	h.factories[kind] = factory
+}
+
+// 启动所有worker
+func (h *ActorHost) Start() {
+	h.wg.Add(len(h.workers))
+	for _, w := range h.workers {
+		go func(w *actorWorker) {
+			defer h.wg.Done()
+			w.run()
+		}(w)
+	}
+}
+
+// 关闭，所有激活的actor都会失活，等待worker退出
+func (h *ActorHost) Close() {
+	h.locker.Lock()
+	if h.closed {
+		h.locker.Unlock()
+		return
+	}
+	h.closed = true
+	h.locker.Unlock()
+	for _, w := range h.workers {
+		w.handler.Close()
+	}
+	h.wg.Wait()
+}
+
+// 发送消息到(kind, key)对应的actor，sender用于actor回复
+func (h *ActorHost) Request(kind string, key interface{}, sender ISender, msgId uint32, args interface{}) error {
+	h.locker.RLock()
+	closed := h.closed
+	factory := h.factories[kind]
+	h.locker.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	if factory == nil {
+		return ErrActorKindNotFound
+	}
+	m := getMsg()
+	m.typ = msgNormal
+	m.toKey = actorId{kind: kind, key: key}
+	m.sender = sender
+	m.id = msgId
+	m.args = args
+	w := h.workers[(hashString(kind)^hashKey(key))%uint32(len(h.workers))]
+	return w.handler.Send(m)
+}
+
+// 获取工厂
+func (h *ActorHost) factory(kind string) func(key interface{}) IActor {
+	h.locker.RLock()
+	defer h.locker.RUnlock()
+	return h.factories[kind]
+}
+
+// actor的worker，一个goroutine运行多个actor
+type actorWorker struct {
+	host    *ActorHost
+	handler *handler
+	actors  map[actorId]*actorEntry
+}
+
+// 创建worker
+func newActorWorker(host *ActorHost) *actorWorker {
+	return &actorWorker{
+		host:    host,
+		handler: newDefaultHandler(),
+		actors:  make(map[actorId]*actorEntry),
+	}
+}
+
+// 循环处理消息和空闲检查
+func (w *actorWorker) run() {
+	if w.handler.start() != nil {
+		return
+	}
+	defer w.handler.stop()
+	interval := w.host.idleTimeout / 4
+	if interval < ActorMinCheckInterval {
+		interval = ActorMinCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case m := <-w.handler.ch:
+			w.handleMsg(m)
+		case now := <-ticker.C:
+			w.passivate(now)
+		case <-w.handler.chClose:
+			w.deactivateAll()
+			return
+		}
+	}
+}
+
+// 处理消息，actor不存在时激活，类型没有注册或者actor panic时消息作为死信
+func (w *actorWorker) handleMsg(m *msg) {
+	defer putMsg(m)
+	id := m.toKey.(actorId)
+	e, o := w.actors[id]
+	if !o {
+		factory := w.host.factory(id.kind)
+		if factory == nil {
+			publishDeadLetter(DeadLetterNoHandler, m, ErrActorKindNotFound)
+			return
+		}
+		e = &actorEntry{}
+		err := callActor(func() {
+			e.actor = factory(id.key)
+			e.actor.OnActivate(id.kind, id.key)
+		})
+		if err != nil {
+			publishDeadLetter(DeadLetterActorPanic, m, err)
+			return
+		}
+		w.actors[id] = e
+	}
+	e.lastActive = time.Now()
+	err := callActor(func() {
+		e.actor.Receive(m.sender, m.id, m.args)
+	})
+	if err != nil {
+		// 状态可能已经不完整，直接丢弃，下一条消息重新激活
+		delete(w.actors, id)
+		publishDeadLetter(DeadLetterActorPanic, m, err)
+	}
+}
+
+// 空闲超时的actor失活
+func (w *actorWorker) passivate(now time.Time) {
+	for id, e := range w.actors {
+		if now.Sub(e.lastActive) >= w.host.idleTimeout {
+			callActor(e.actor.OnDeactivate)
+			delete(w.actors, id)
+		}
+	}
+}
+
+// 所有actor失活
+func (w *actorWorker) deactivateAll() {
+	for id, e := range w.actors {
+		callActor(e.actor.OnDeactivate)
+		delete(w.actors, id)
+	}
+}
+
+// 调用actor的方法，panic转成错误，不让一个actor的panic退出共用的worker
+func callActor(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("gproc: actor panic: %v", r)
+		}
+	}()
+	f()
+	return nil
+}
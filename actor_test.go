@@ -0,0 +1,143 @@
+package gproc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// 计数actor的持久化存储
+type counterStore struct {
+	locker      sync.Mutex
+	counts      map[interface{}]int
+	activated   int
+	deactivated int
+}
+
+// 计数actor
+type counterActor struct {
+	store *counterStore
+	key   interface{}
+	count int
+}
+
+func (a *counterActor) OnActivate(kind string, key interface{}) {
+	a.store.locker.Lock()
+	defer a.store.locker.Unlock()
+	a.key = key
+	a.count = a.store.counts[key]
+	a.store.activated += 1
+}
+
+func (a *counterActor) Receive(sender ISender, msgId uint32, args interface{}) {
+	a.count += 1
+	sender.Send(msgId, [2]interface{}{a.key, a.count})
+}
+
+func (a *counterActor) OnDeactivate() {
+	a.store.locker.Lock()
+	defer a.store.locker.Unlock()
+	a.store.counts[a.key] = a.count
+	a.store.deactivated += 1
+}
+
+func TestActorHost(t *testing.T) {
+	store := &counterStore{counts: make(map[interface{}]int)}
+	host := NewActorHost(2, time.Millisecond*30)
+	host.RegisterKind("counter", func(key interface{}) IActor {
+		return &counterActor{store: store}
+	})
+	host.Start()
+	defer host.Close()
+
+	if err := host.Request("unknown", 1, nil, MsgIdIncrCounter, nil); err != ErrActorKindNotFound {
+		t.Fatalf("expect ErrActorKindNotFound, got %v", err)
+	}
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	counts := make(map[interface{}]int)
+	p.CreateRequester(NewDefaultRequestHandler(), 0).RegisterCallback(MsgIdIncrCounter, func(args interface{}) {
+		resp := args.([2]interface{})
+		counts[resp[0]] = resp[1].(int)
+	})
+	incr := func(expect int) {
+		for key := 1; key <= 3; key++ {
+			if err := host.Request("counter", key, p, MsgIdIncrCounter, nil); err != nil {
+				t.Fatalf("request actor err: %v", err)
+			}
+		}
+		waitUntil(t, func() bool {
+			return counts[1] == expect && counts[2] == expect && counts[3] == expect
+		}, p)
+	}
+
+	incr(1)
+	incr(2)
+	// 空闲超时后失活，状态被保存
+	waitUntil(t, func() bool {
+		store.locker.Lock()
+		defer store.locker.Unlock()
+		return store.deactivated == 3
+	})
+	// 再次发送时重新激活并恢复状态
+	incr(3)
+	store.locker.Lock()
+	activated := store.activated
+	store.locker.Unlock()
+	if activated != 6 {
+		t.Fatalf("expect 6 activations, got %v", activated)
+	}
+}
+
+// 处理消息时panic的actor
+type panicActor struct{}
+
+func (a *panicActor) OnActivate(kind string, key interface{}) {}
+
+func (a *panicActor) Receive(sender ISender, msgId uint32, args interface{}) {
+	panic("boom")
+}
+
+func (a *panicActor) OnDeactivate() {}
+
+func TestActorHostPanic(t *testing.T) {
+	dl := NewDeadLetters(10)
+	old := GetDeadLetters()
+	SetDeadLetters(dl)
+	defer SetDeadLetters(old)
+
+	store := &counterStore{counts: make(map[interface{}]int)}
+	// 只有一个worker，所有actor共用
+	host := NewActorHost(1, time.Minute)
+	host.RegisterKind("counter", func(key interface{}) IActor {
+		return &counterActor{store: store}
+	})
+	host.RegisterKind("panic", func(key interface{}) IActor {
+		return &panicActor{}
+	})
+	host.RegisterKind("nil", func(key interface{}) IActor {
+		return nil
+	})
+	host.RegisterKind("unregistered", nil)
+	host.Start()
+	defer host.Close()
+
+	if err := host.Request("unregistered", 1, nil, MsgIdIncrCounter, nil); err != ErrActorKindNotFound {
+		t.Fatalf("expect ErrActorKindNotFound, got %v", err)
+	}
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	var count interface{}
+	p.CreateRequester(NewDefaultRequestHandler(), 0).RegisterCallback(MsgIdIncrCounter, func(args interface{}) {
+		count = args.([2]interface{})[1]
+	})
+	host.Request("panic", 1, p, MsgIdIncrCounter, nil)
+	host.Request("nil", 1, p, MsgIdIncrCounter, nil)
+	// panic的actor不影响同一个worker上的其他actor
+	host.Request("counter", 1, p, MsgIdIncrCounter, nil)
+	waitUntil(t, func() bool { return count == 1 }, p)
+	if c := dl.Count(DeadLetterActorPanic); c != 2 {
+		t.Fatalf("expect 2 actor panic dead letters, got %v", c)
+	}
+}
@@ -0,0 +1,85 @@
+package gproc
+
+// 行为，msgId到处理函数的映射，Become后整体替换注册的处理函数
+type Behavior map[uint32]func(sender ISender, args interface{})
+
+// 切换到新的行为，之前的行为压栈，只能在处理器的goroutine中调用
+func (h *RequestHandler) Become(behavior Behavior) {
+	h.behaviors = append(h.behaviors, behavior)
+}
+
+// 恢复到上一个行为，没有Become过时返回false
+func (h *RequestHandler) Unbecome() bool {
+	n := len(h.behaviors)
+	if n == 0 {
+		return false
+	}
+	h.behaviors[n-1] = nil
+	h.behaviors = h.behaviors[:n-1]
+	return true
+}
+
+// 暂存正在处理的请求，只能在处理函数中调用
+func (h *RequestHandler) Stash() error {
+	if h.current == nil {
+		return ErrStashNoMessage
+	}
+	// 正在处理的消息处理完就会回收，暂存一份拷贝，持久邮箱的确认也留给拷贝
+	m := getMsg()
+	*m = *h.current
+	h.current.walSeq = 0
+	h.stash = append(h.stash, m)
+	return nil
+}
+
+// 暂存的请求数量
+func (h *RequestHandler) StashSize() int {
+	return len(h.stash)
+}
+
+// 重放所有暂存的请求，在当前消息处理完后、邮箱中的消息之前按暂存的顺序处理
+func (h *RequestHandler) UnstashAll() {
+	if len(h.stash) == 0 {
+		return
+	}
+	h.unstashed = append(h.stash, h.unstashed...)
+	h.stash = nil
+}
+
+// 处理等待重放的暂存消息
+func (h *RequestHandler) replayUnstashed() {
+	for len(h.unstashed) > 0 {
+		m := h.unstashed[0]
+		h.unstashed[0] = nil
+		h.unstashed = h.unstashed[1:]
+		if err := h.handleMsg(m); err != nil {
+			publishDeadLetter(requestDeadLetterReason(m), m, err)
+		}
+		putMsg(m)
+	}
+}
+
+// 切换到新的行为
+func (s *LocalService) Become(behavior Behavior) {
+	s.requestHandler.Become(behavior)
+}
+
+// 恢复到上一个行为
+func (s *LocalService) Unbecome() bool {
+	return s.requestHandler.Unbecome()
+}
+
+// 暂存正在处理的请求
+func (s *LocalService) Stash() error {
+	return s.requestHandler.Stash()
+}
+
+// 暂存的请求数量
+func (s *LocalService) StashSize() int {
+	return s.requestHandler.StashSize()
+}
+
+// 重放所有暂存的请求
+func (s *LocalService) UnstashAll() {
+	s.requestHandler.UnstashAll()
+}
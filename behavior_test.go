@@ -0,0 +1,95 @@
+package gproc
+
+import (
+	"testing"
+)
+
+// 好友数据加载完成前暂存加好友请求，加载完成后切回正常行为并按顺序重放
+func TestBecomeAndStash(t *testing.T) {
+	service := NewDefaultLocalService()
+	service.RegisterHandle(MsgIdFriendAdd, func(sender ISender, args interface{}) {
+		sender.Send(MsgIdFriendAdd, args)
+	})
+	var loading Behavior
+	loading = Behavior{
+		MsgIdFriendAdd: func(sender ISender, args interface{}) {
+			if err := service.Stash(); err != nil {
+				t.Errorf("stash err: %v", err)
+			}
+		},
+		MsgIdUpdateFriendInfo: func(sender ISender, args interface{}) {
+			if service.StashSize() != 3 {
+				t.Errorf("expect 3 stashed, got %v", service.StashSize())
+			}
+			service.Unbecome()
+			service.UnstashAll()
+		},
+	}
+	service.Become(loading)
+	if err := service.Stash(); err != ErrStashNoMessage {
+		t.Fatalf("expect ErrStashNoMessage, got %v", err)
+	}
+	go service.Run()
+	defer service.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(service, "player")
+	var added []interface{}
+	r.RegisterCallback(MsgIdFriendAdd, func(args interface{}) {
+		added = append(added, args)
+	})
+	for i := 1; i <= 3; i++ {
+		r.Request(MsgIdFriendAdd, i)
+	}
+	r.Request(MsgIdUpdateFriendInfo, nil)
+	r.Request(MsgIdFriendAdd, 4)
+	waitUntil(t, func() bool { return len(added) == 4 }, p)
+	for i, a := range added {
+		if a != i+1 {
+			t.Fatalf("expect replayed in order, got %v", added)
+		}
+	}
+	if service.Unbecome() {
+		t.Fatalf("unbecome without become should return false")
+	}
+}
+
+// 重放时再次暂存的消息留到下一次UnstashAll
+func TestStashDuringReplay(t *testing.T) {
+	h := NewDefaultRequestHandler()
+	var handled []interface{}
+	h.Become(Behavior{
+		MsgIdFriendAdd: func(sender ISender, args interface{}) {
+			h.Stash()
+		},
+	})
+	h.RegisterHandle(MsgIdFriendAdd, func(sender ISender, args interface{}) {
+		handled = append(handled, args)
+	})
+	for i := 0; i < 2; i++ {
+		m := getMsg()
+		m.typ = msgNormal
+		m.id = MsgIdFriendAdd
+		m.args = i
+		h.processMsg(m)
+	}
+	// 行为不变时重放的消息又被暂存
+	h.UnstashAll()
+	m := getMsg()
+	m.typ = msgExec
+	m.args = func() {}
+	h.processMsg(m)
+	if len(handled) != 0 || h.StashSize() != 2 {
+		t.Fatalf("expect messages stashed again, handled %v stash %v", handled, h.StashSize())
+	}
+	h.Unbecome()
+	h.UnstashAll()
+	m = getMsg()
+	m.typ = msgExec
+	m.args = func() {}
+	h.processMsg(m)
+	if len(handled) != 2 || handled[0] != 0 || handled[1] != 1 || h.StashSize() != 0 {
+		t.Fatalf("unexpected handled %v", handled)
+	}
+}
@@ -0,0 +1,240 @@
+package gproc
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	CircuitFailureThreshold = 5                              // 默认连续失败多少次后断开
+	CircuitOpenTimeout      = time.Duration(5 * time.Second) // 默认断开多久后半开探测
+	CircuitHalfOpenProbes   = 1                              // 默认半开时同时允许的探测请求数
+	CircuitSuccessThreshold = 1                              // 默认半开时探测成功多少次后闭合
+)
+
+// 熔断器状态
+type CircuitState int32
+
+const (
+	CircuitClosed   CircuitState = iota // 闭合，请求正常通过
+	CircuitOpen                         // 断开，请求直接返回ErrCircuitOpen
+	CircuitHalfOpen                     // 半开，允许少量探测请求通过
+)
+
+var circuitStateNames = []string{
+	CircuitClosed:   "closed",
+	CircuitOpen:     "open",
+	CircuitHalfOpen: "half-open",
+}
+
+func (s CircuitState) String() string {
+	if s < 0 || int(s) >= len(circuitStateNames) {
+		return "unknown"
+	}
+	return circuitStateNames[s]
+}
+
+// 熔断器配置
+type CircuitBreakerConfig struct {
+	Name             string                                                // 名字，用于指标
+	FailureThreshold int                                                   // 连续失败多少次后断开
+	OpenTimeout      time.Duration                                         // 断开多久后半开探测
+	HalfOpenProbes   int                                                   // 半开时同时允许的探测请求数
+	SuccessThreshold int                                                   // 半开时探测成功多少次后闭合
+	OnStateChange    func(name string, from CircuitState, to CircuitState) // 状态变化的指标钩子，不在锁内调用
+}
+
+// 熔断器统计
+type CircuitBreakerStats struct {
+	State     CircuitState
+	Requests  uint64 // 通过的请求数
+	Rejected  uint64 // 被熔断拒绝的请求数
+	Successes uint64 // 成功数
+	Failures  uint64 // 失败数，包括通道满、服务关闭和请求超时
+}
+
+// 熔断结果报告接口，设置了超时的请求由Requester在返回或超时时报告
+type circuitReporter interface {
+	report(err error)
+}
+
+// 熔断器，包装到目标服务的IRequestHandler，每个目标服务一个
+// 请求不等待满的通道，通道满、服务关闭和请求超时都算作失败，连续失败达到阈值后断开，
+// 断开期间请求直接返回ErrCircuitOpen，超时后半开放行探测请求，探测成功闭合，失败重新断开
+// 普通请求和按key的请求受熔断影响，报名、注销等控制消息不受熔断影响
+type CircuitBreaker struct {
+	IRequestHandler
+	locker   sync.Mutex
+	config   CircuitBreakerConfig
+	state    CircuitState
+	failures int       // 闭合时连续失败次数
+	probes   int       // 半开时进行中的探测请求数
+	probeOk  int       // 半开时探测成功次数
+	openedAt time.Time // 断开的时间
+	stats    CircuitBreakerStats
+}
+
+// 创建熔断器
+func NewCircuitBreaker(target IRequestHandler, config CircuitBreakerConfig) *CircuitBreaker {
+	if target == nil {
+		panic("circuit breaker target is nil")
+	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = CircuitFailureThreshold
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = CircuitOpenTimeout
+	}
+	if config.HalfOpenProbes <= 0 {
+		config.HalfOpenProbes = CircuitHalfOpenProbes
+	}
+	if config.SuccessThreshold <= 0 {
+		config.SuccessThreshold = CircuitSuccessThreshold
+	}
+	return &CircuitBreaker{
+		IRequestHandler: target,
+		config:          config,
+	}
+}
+
+// 创建默认配置的熔断器
+func NewDefaultCircuitBreaker(target IRequestHandler) *CircuitBreaker {
+	return NewCircuitBreaker(target, CircuitBreakerConfig{})
+}
+
+// 当前状态，断开超时后返回半开
+func (b *CircuitBreaker) State() CircuitState {
+	b.locker.Lock()
+	from, to := b.refresh(time.Now())
+	state := b.state
+	b.locker.Unlock()
+	b.notify(from, to)
+	return state
+}
+
+// 统计
+func (b *CircuitBreaker) Stats() CircuitBreakerStats {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	stats := b.stats
+	stats.State = b.state
+	return stats
+}
+
+// 手动重置为闭合
+func (b *CircuitBreaker) Reset() {
+	b.locker.Lock()
+	from, to := b.setState(CircuitClosed, time.Now())
+	b.locker.Unlock()
+	b.notify(from, to)
+}
+
+// 接收请求，断开时直接拒绝
+func (b *CircuitBreaker) recv(m *msg) error {
+	if m.typ != msgNormal && m.typ != msgKeyRequest {
+		return b.IRequestHandler.recv(m)
+	}
+	if !b.allow() {
+		putMsg(m)
+		return ErrCircuitOpen
+	}
+	tracked := m.tracked
+	m.noWait = true
+	err := b.IRequestHandler.recv(m)
+	if err != nil || !tracked {
+		b.report(err)
+	}
+	return err
+}
+
+// 是否放行请求
+func (b *CircuitBreaker) allow() bool {
+	b.locker.Lock()
+	from, to := b.refresh(time.Now())
+	allowed := true
+	switch b.state {
+	case CircuitOpen:
+		allowed = false
+	case CircuitHalfOpen:
+		if b.probes >= b.config.HalfOpenProbes {
+			allowed = false
+		} else {
+			b.probes += 1
+		}
+	}
+	if allowed {
+		b.stats.Requests += 1
+	} else {
+		b.stats.Rejected += 1
+	}
+	b.locker.Unlock()
+	b.notify(from, to)
+	return allowed
+}
+
+// 报告请求结果
+func (b *CircuitBreaker) report(err error) {
+	now := time.Now()
+	b.locker.Lock()
+	var from, to CircuitState
+	if err == nil {
+		b.stats.Successes += 1
+		switch b.state {
+		case CircuitClosed:
+			b.failures = 0
+		case CircuitHalfOpen:
+			if b.probes > 0 {
+				b.probes -= 1
+			}
+			b.probeOk += 1
+			if b.probeOk >= b.config.SuccessThreshold {
+				from, to = b.setState(CircuitClosed, now)
+			}
+		}
+	} else {
+		b.stats.Failures += 1
+		switch b.state {
+		case CircuitClosed:
+			b.failures += 1
+			if b.failures >= b.config.FailureThreshold {
+				from, to = b.setState(CircuitOpen, now)
+			}
+		case CircuitHalfOpen:
+			from, to = b.setState(CircuitOpen, now)
+		}
+	}
+	b.locker.Unlock()
+	b.notify(from, to)
+}
+
+// 断开超时后转为半开，需要持有锁
+func (b *CircuitBreaker) refresh(now time.Time) (CircuitState, CircuitState) {
+	if b.state == CircuitOpen && now.Sub(b.openedAt) >= b.config.OpenTimeout {
+		return b.setState(CircuitHalfOpen, now)
+	}
+	return 0, 0
+}
+
+// 设置状态，返回变化前后的状态，需要持有锁
+func (b *CircuitBreaker) setState(state CircuitState, now time.Time) (CircuitState, CircuitState) {
+	from := b.state
+	if from == state {
+		return 0, 0
+	}
+	b.state = state
+	b.failures = 0
+	b.probes = 0
+	b.probeOk = 0
+	if state == CircuitOpen {
+		b.openedAt = now
+	}
+	return from, state
+}
+
+// 通知状态变化
+func (b *CircuitBreaker) notify(from, to CircuitState) {
+	if from == to || b.config.OnStateChange == nil {
+		return
+	}
+	b.config.OnStateChange(b.config.Name, from, to)
+}
@@ -0,0 +1,145 @@
+package gproc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type circuitTransitions struct {
+	locker      sync.Mutex
+	transitions []CircuitState
+}
+
+func (c *circuitTransitions) onStateChange(name string, from, to CircuitState) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.transitions = append(c.transitions, to)
+}
+
+func (c *circuitTransitions) get() []CircuitState {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return append([]CircuitState(nil), c.transitions...)
+}
+
+// 目标服务停顿时通道满，熔断器断开后快速失败，服务恢复后半开探测成功闭合
+func TestCircuitBreakerMailboxFull(t *testing.T) {
+	service := NewLocalService(1)
+	service.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+		sender.Send(MsgIdBuyItem, args)
+	})
+	defer service.Close()
+
+	var c circuitTransitions
+	breaker := NewCircuitBreaker(service, CircuitBreakerConfig{
+		Name:             "service",
+		FailureThreshold: 3,
+		OpenTimeout:      time.Millisecond * 50,
+		OnStateChange:    c.onStateChange,
+	})
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	// 报名消息占满通道
+	r := p.CreateRequester(breaker, "player")
+	var resp interface{}
+	r.RegisterCallback(MsgIdBuyItem, func(args interface{}) {
+		resp = args
+	})
+	for i := 0; i < 3; i++ {
+		if err := r.Request(MsgIdBuyItem, i); err != ErrMailboxFull {
+			t.Fatalf("expect ErrMailboxFull, got %v", err)
+		}
+	}
+	if err := r.Request(MsgIdBuyItem, 3); err != ErrCircuitOpen {
+		t.Fatalf("expect ErrCircuitOpen, got %v", err)
+	}
+	if s := breaker.Stats(); s.Failures != 3 || s.Rejected != 1 || s.State != CircuitOpen {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	go service.Run()
+	waitUntil(t, func() bool { return len(service.handler.ch) == 0 && breaker.State() == CircuitHalfOpen })
+	if err := r.Request(MsgIdBuyItem, 4); err != nil {
+		t.Fatalf("probe request err: %v", err)
+	}
+	if breaker.State() != CircuitClosed {
+		t.Fatalf("expect closed after probe, got %v", breaker.State())
+	}
+	waitUntil(t, func() bool { return resp == 4 }, p)
+
+	expect := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	got := c.get()
+	if len(got) != len(expect) {
+		t.Fatalf("expect transitions %v, got %v", expect, got)
+	}
+	for i := range expect {
+		if got[i] != expect[i] {
+			t.Fatalf("expect transitions %v, got %v", expect, got)
+		}
+	}
+}
+
+// 请求超时由requester报告给熔断器，半开时探测失败重新断开
+func TestCircuitBreakerTimeout(t *testing.T) {
+	service := NewDefaultLocalService()
+	// 从不返回
+	service.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {})
+	go service.Run()
+	defer service.Close()
+
+	var c circuitTransitions
+	breaker := NewCircuitBreaker(service, CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Millisecond * 30,
+		OnStateChange:    c.onStateChange,
+	})
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	var failures []error
+	r := p.CreateRequester(breaker, "player", RequestTimeout(10), RequestFailHandle(func(msgId uint32, args interface{}, err error) {
+		failures = append(failures, err)
+	}))
+	r.Request(MsgIdBuyItem, 1)
+	r.Request(MsgIdBuyItem, 2)
+	if breaker.State() != CircuitClosed {
+		t.Fatalf("expect closed before timeout, got %v", breaker.State())
+	}
+	waitUntil(t, func() bool { return len(failures) == 2 }, p)
+	if breaker.State() != CircuitOpen {
+		t.Fatalf("expect open after timeouts, got %v", breaker.State())
+	}
+	if err := r.Request(MsgIdBuyItem, 3); err != ErrCircuitOpen {
+		t.Fatalf("expect ErrCircuitOpen, got %v", err)
+	}
+
+	// 半开时只放行一个探测请求，探测超时后重新断开
+	waitUntil(t, func() bool { return breaker.State() == CircuitHalfOpen })
+	if err := r.Request(MsgIdBuyItem, 4); err != nil {
+		t.Fatalf("probe request err: %v", err)
+	}
+	if err := r.Request(MsgIdBuyItem, 5); err != ErrCircuitOpen {
+		t.Fatalf("expect ErrCircuitOpen while probing, got %v", err)
+	}
+	waitUntil(t, func() bool { return len(failures) == 3 }, p)
+	if breaker.State() != CircuitOpen {
+		t.Fatalf("expect open after probe timeout, got %v", breaker.State())
+	}
+
+	breaker.Reset()
+	if breaker.State() != CircuitClosed {
+		t.Fatalf("expect closed after reset, got %v", breaker.State())
+	}
+	expect := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitClosed}
+	got := c.get()
+	if len(got) != len(expect) {
+		t.Fatalf("expect transitions %v, got %v", expect, got)
+	}
+	for i := range expect {
+		if got[i] != expect[i] {
+			t.Fatalf("expect transitions %v, got %v", expect, got)
+		}
+	}
+}
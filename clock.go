@@ -0,0 +1,141 @@
+package gproc
+
+import (
+	"time"
+)
+
+// 时钟接口，测试时可以替换成手动推进的虚拟时钟
+type Clock interface {
+	// 当前时间
+	Now() time.Time
+}
+
+// 系统时钟
+type SystemClock struct{}
+
+// 当前时间
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// 设置时钟，定时器按这个时钟计算到期时间
+func (h *ResponseHandler) SetClock(clock Clock) {
+	h.clock = clock
+}
+
+// 当前时间
+func (h *ResponseHandler) now() time.Time {
+	if h.clock == nil {
+		return time.Now()
+	}
+	return h.clock.Now()
+}
+
+// 通道中等待处理的消息数
+func (h *ResponseHandler) Pending() int {
+	return len(h.handler.ch)
+}
+
+// 处理一条返回消息，没有消息或者已关闭时返回false，用于不调用Update的逐步执行
+func (h *ResponseHandler) Step() bool {
+	if h.handler.IsClosed() {
+		return false
+	}
+	select {
+	case m := <-h.handler.ch:
+		h.processResp(m)
+		return true
+	default:
+		return false
+	}
+}
+
+// 执行到now为止到期的定时器
+func (h *ResponseHandler) RunTimers(now time.Time) {
+	h.runTimers(now)
+}
+
+// 设置逐步执行，通道满时阻塞的发送改为panic(ErrSteppingMailboxFull)，在Step之前调用
+func (h *ResponseHandler) SetStepping(stepping bool) {
+	h.handler.stepping = stepping
+}
+
+// 通道中等待处理的消息数
+func (h *RequestHandler) Pending() int {
+	return len(h.handler.ch)
+}
+
+// 处理一条请求消息，没有消息或者已关闭时返回false，用于不调用Run的逐步执行
+func (h *RequestHandler) Step() bool {
+	if h.handler.IsClosed() {
+		return false
+	}
+	select {
+	case m := <-h.handler.ch:
+		h.processMsg(m)
+		return true
+	default:
+		return false
+	}
+}
+
+// 执行到now为止的定时处理，时间跨过多个定时间隔时按间隔执行多次
+func (h *RequestHandler) RunTimers(now time.Time) {
+	h.runTicks(now, &h.lastTick)
+}
+
+// 设置逐步执行，通道满时阻塞的发送改为panic(ErrSteppingMailboxFull)，在Step之前调用
+func (h *RequestHandler) SetStepping(stepping bool) {
+	h.handler.stepping = stepping
+}
+
+// 执行到now为止的定时处理，last是上次定时处理的时间，第一次只记录时间
+func (h *RequestHandler) runTicks(now time.Time, last *time.Time) {
+	if h.tickHandle == nil {
+		return
+	}
+	if last.IsZero() {
+		*last = now
+		return
+	}
+	for now.Sub(*last) >= h.tick {
+		*last = last.Add(h.tick)
+		h.tickHandle(h.tick)
+	}
+}
+
+// 设置时钟，定时器和定时处理按这个时钟计算
+func (s *LocalService) SetClock(clock Clock) {
+	s.responseHandler.SetClock(clock)
+	s.lastTick = clock.Now()
+}
+
+// 邮箱中等待处理的消息数
+func (s *LocalService) Pending() int {
+	return len(s.handler.ch)
+}
+
+// 处理一条消息，没有消息或者已关闭时返回false，用于不调用Run的逐步执行
+func (s *LocalService) Step() bool {
+	if s.handler.IsClosed() {
+		return false
+	}
+	select {
+	case m := <-s.handler.ch:
+		s.processMsg(m)
+		return true
+	default:
+		return false
+	}
+}
+
+// 执行到now为止到期的定时器和定时处理，时间跨过多个定时间隔时定时处理按间隔执行多次
+func (s *LocalService) RunTimers(now time.Time) {
+	s.responseHandler.runTimers(now)
+	s.requestHandler.runTicks(now, &s.lastTick)
+}
+
+// 设置逐步执行，邮箱满时阻塞的发送改为panic(ErrSteppingMailboxFull)，在Step之前调用
+func (s *LocalService) SetStepping(stepping bool) {
+	s.handler.stepping = stepping
+}
@@ -0,0 +1,124 @@
+// gproc-seq把gproc.TraceFile记录的消息轨迹转成Mermaid或PlantUML时序图
+//
+//	gproc-seq -format mermaid -names msg_names.txt -keys 1001,1002 -from 2021-01-01T00:00:00Z shop.trace > shop.mmd
+//
+// 名字文件每行一个"消息id 名字"，#开头的行是注释
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/huoshan017/gproc"
+)
+
+func main() {
+	format := flag.String("format", "mermaid", "output format: mermaid or plantuml")
+	title := flag.String("title", "", "diagram title")
+	names := flag.String("names", "", "file of \"msgId name\" lines")
+	keys := flag.String("keys", "", "comma separated keys to keep, empty keeps all")
+	from := flag.String("from", "", "start of time window, RFC3339")
+	to := flag.String("to", "", "end of time window, RFC3339")
+	output := flag.String("o", "", "output file, default stdout")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gproc-seq [flags] trace-file")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	var options gproc.SequenceOptions
+	var o bool
+	if options.Format, o = gproc.ParseSequenceFormat(*format); !o {
+		exit(fmt.Errorf("unknown format %v", *format))
+	}
+	options.Title = *title
+	if *names != "" {
+		m, err := readNames(*names)
+		if err != nil {
+			exit(err)
+		}
+		options.MsgNames = m
+	}
+	if *keys != "" {
+		for _, k := range strings.Split(*keys, ",") {
+			options.Keys = append(options.Keys, strings.TrimSpace(k))
+		}
+	}
+	var err error
+	if options.Start, err = parseTime(*from); err != nil {
+		exit(err)
+	}
+	if options.End, err = parseTime(*to); err != nil {
+		exit(err)
+	}
+
+	if err = run(flag.Arg(0), *output, options); err != nil {
+		exit(err)
+	}
+}
+
+// 读取轨迹文件写出时序图，输出文件在返回前关闭，关闭的错误也返回
+func run(path string, output string, options gproc.SequenceOptions) error {
+	events, err := gproc.ReadTraceFile(path, nil)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		return gproc.WriteSequenceDiagram(os.Stdout, events, options)
+	}
+	w, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	err = gproc.WriteSequenceDiagram(w, events, options)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// 读取消息名字文件
+func readNames(path string) (map[uint32]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	names := make(map[uint32]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%v:%v: expect \"msgId name\"", path, line)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %v", path, line, err)
+		}
+		names[uint32(id)] = fields[1]
+	}
+	return names, scanner.Err()
+}
+
+// 解析时间，空字符串返回零值
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, "gproc-seq:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,158 @@
+package gproc
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// 编解码接口
+type ICodec interface {
+	// 编码
+	Marshal(v interface{}) ([]byte, error)
+	// 解码到指针v
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// gob编解码
+type GobCodec struct{}
+
+// 编码
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 解码
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// 注册的类型
+type codecType struct {
+	id   uint32
+	name string
+	typ  reflect.Type
+}
+
+// 编解码注册表，类型按id和名字注册，编码结果带上类型id，解码时按id创建原型的实例
+// id为0保留给nil
+type CodecRegistry struct {
+	locker sync.RWMutex
+	codec  ICodec
+	byId   map[uint32]*codecType
+	byType map[reflect.Type]*codecType
+}
+
+// 创建编解码注册表，codec为nil时使用gob
+func NewCodecRegistry(codec ICodec) *CodecRegistry {
+	if codec == nil {
+		codec = GobCodec{}
+	}
+	return &CodecRegistry{
+		codec:  codec,
+		byId:   make(map[uint32]*codecType),
+		byType: make(map[reflect.Type]*codecType),
+	}
+}
+
+// 注册类型，prototype是类型的零值或者指针
+func (r *CodecRegistry) Register(id uint32, name string, prototype interface{}) error {
+	if id == 0 || prototype == nil {
+		return ErrCodecInvalidType
+	}
+	typ := reflect.TypeOf(prototype)
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if t, o := r.byId[id]; o && t.typ != typ {
+		return ErrCodecIdExists
+	}
+	t := &codecType{id: id, name: name, typ: typ}
+	r.byId[id] = t
+	r.byType[typ] = t
+	return nil
+}
+
+// 类型id对应的名字
+func (r *CodecRegistry) Name(id uint32) (string, bool) {
+	r.locker.RLock()
+	defer r.locker.RUnlock()
+	t, o := r.byId[id]
+	if !o {
+		return "", false
+	}
+	return t.name, true
+}
+
+// 值的类型id
+func (r *CodecRegistry) Id(v interface{}) (uint32, bool) {
+	r.locker.RLock()
+	defer r.locker.RUnlock()
+	t, o := r.byType[reflect.TypeOf(v)]
+	if !o {
+		return 0, false
+	}
+	return t.id, true
+}
+
+// 编码，返回类型id和数据
+func (r *CodecRegistry) Encode(v interface{}) (uint32, []byte, error) {
+	if v == nil {
+		return 0, nil, nil
+	}
+	id, o := r.Id(v)
+	if !o {
+		return 0, nil, ErrCodecTypeNotRegistered
+	}
+	data, err := r.codec.Marshal(v)
+	if err != nil {
+		return 0, nil, err
+	}
+	return id, data, nil
+}
+
+// 按类型id解码
+func (r *CodecRegistry) Decode(id uint32, data []byte) (interface{}, error) {
+	if id == 0 {
+		return nil, nil
+	}
+	r.locker.RLock()
+	t, o := r.byId[id]
+	r.locker.RUnlock()
+	if !o {
+		return nil, ErrCodecTypeNotRegistered
+	}
+	if t.typ.Kind() == reflect.Ptr {
+		v := reflect.New(t.typ.Elem())
+		if err := r.codec.Unmarshal(data, v.Interface()); err != nil {
+			return nil, err
+		}
+		return v.Interface(), nil
+	}
+	v := reflect.New(t.typ)
+	if err := r.codec.Unmarshal(data, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+var codecRegistry atomic.Value
+
+func init() {
+	codecRegistry.Store(NewCodecRegistry(nil))
+}
+
+// 获取全局编解码注册表，消息的参数按msgId注册
+func GetCodecRegistry() *CodecRegistry {
+	return codecRegistry.Load().(*CodecRegistry)
+}
+
+// 替换全局编解码注册表
+func SetCodecRegistry(r *CodecRegistry) {
+	codecRegistry.Store(r)
+}
@@ -0,0 +1,189 @@
+package gproc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DeadLettersCapacity = 1000 // 默认保留的最近死信数量
+)
+
+// 死信原因
+type DeadLetterReason uint8
+
+const (
+	DeadLetterNoHandler         DeadLetterReason = 0 // 请求没有注册处理函数
+	DeadLetterUnclaimedResponse DeadLetterReason = 1 // 返回没有requester处理
+	DeadLetterClosed            DeadLetterReason = 2 // 发送到已关闭的处理器
+	DeadLetterForwardFailed     DeadLetterReason = 3 // 转发失败
+	DeadLetterNotifyFailed      DeadLetterReason = 4 // 通知失败
+	DeadLetterMailboxFull       DeadLetterReason = 5 // 不等待的消息因通道满被拒绝
+	DeadLetterWorkerRetired     DeadLetterReason = 6 // 路由器移除的worker邮箱中的消息，剩下的worker放不下
+	DeadLetterActorPanic        DeadLetterReason = 7 // actor激活或者处理消息时panic
+	deadLetterReasonCount                        = 8
+)
+
+// 原因名
+func (r DeadLetterReason) String() string {
+	switch r {
+	case DeadLetterNoHandler:
+		return "no_handler"
+	case DeadLetterUnclaimedResponse:
+		return "unclaimed_response"
+	case DeadLetterClosed:
+		return "closed"
+	case DeadLetterForwardFailed:
+		return "forward_failed"
+	case DeadLetterNotifyFailed:
+		return "notify_failed"
+	case DeadLetterMailboxFull:
+		return "mailbox_full"
+	case DeadLetterWorkerRetired:
+		return "worker_retired"
+	case DeadLetterActorPanic:
+		return "actor_panic"
+	}
+	return "unknown"
+}
+
+// 死信，无法投递或者没有被处理的消息
+type DeadLetter struct {
+	Reason  DeadLetterReason
+	MsgType string
+	MsgId   uint32
+	FromKey interface{}
+	ToKey   interface{}
+	Args    interface{}
+	Err     error
+	Time    time.Time
+}
+
+// 死信收集器，只保留最近的capacity条，并按原因计数，订阅函数在产生死信的goroutine中同步调用
+type DeadLetters struct {
+	locker      sync.Mutex
+	recent      []*DeadLetter
+	next        int
+	full        bool
+	counts      [deadLetterReasonCount]uint64
+	subscribers map[uint64]func(*DeadLetter)
+	subscribeId uint64
+}
+
+// 创建死信收集器
+func NewDeadLetters(capacity int) *DeadLetters {
+	if capacity <= 0 {
+		capacity = DeadLettersCapacity
+	}
+	return &DeadLetters{
+		recent:      make([]*DeadLetter, capacity),
+		subscribers: make(map[uint64]func(*DeadLetter)),
+	}
+}
+
+// 发布死信
+func (d *DeadLetters) Publish(dl *DeadLetter) {
+	if int(dl.Reason) < deadLetterReasonCount {
+		atomic.AddUint64(&d.counts[dl.Reason], 1)
+	}
+	d.locker.Lock()
+	d.recent[d.next] = dl
+	d.next += 1
+	if d.next == len(d.recent) {
+		d.next = 0
+		d.full = true
+	}
+	var subscribers []func(*DeadLetter)
+	for _, s := range d.subscribers {
+		subscribers = append(subscribers, s)
+	}
+	d.locker.Unlock()
+	for _, s := range subscribers {
+		s(dl)
+	}
+}
+
+// 订阅死信，返回取消订阅的函数
+func (d *DeadLetters) Subscribe(subscriber func(*DeadLetter)) func() {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	d.subscribeId += 1
+	id := d.subscribeId
+	d.subscribers[id] = subscriber
+	return func() {
+		d.locker.Lock()
+		defer d.locker.Unlock()
+		delete(d.subscribers, id)
+	}
+}
+
+// 某个原因的死信数量
+func (d *DeadLetters) Count(reason DeadLetterReason) uint64 {
+	if int(reason) >= deadLetterReasonCount {
+		return 0
+	}
+	return atomic.LoadUint64(&d.counts[reason])
+}
+
+// 死信总数
+func (d *DeadLetters) Total() uint64 {
+	var total uint64
+	for i := 0; i < deadLetterReasonCount; i++ {
+		total += atomic.LoadUint64(&d.counts[i])
+	}
+	return total
+}
+
+// 最近的死信，从旧到新
+func (d *DeadLetters) Recent() []*DeadLetter {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	var list []*DeadLetter
+	if d.full {
+		list = append(list, d.recent[d.next:]...)
+	}
+	return append(list, d.recent[:d.next]...)
+}
+
+// 全局死信收集器
+var deadLetters atomic.Value
+
+func init() {
+	deadLetters.Store(NewDeadLetters(0))
+}
+
+// 获取全局死信收集器
+func GetDeadLetters() *DeadLetters {
+	return deadLetters.Load().(*DeadLetters)
+}
+
+// 替换全局死信收集器
+func SetDeadLetters(d *DeadLetters) {
+	deadLetters.Store(d)
+}
+
+// 把消息作为死信发布
+func publishDeadLetter(reason DeadLetterReason, m *msg, err error) {
+	GetDeadLetters().Publish(&DeadLetter{
+		Reason:  reason,
+		MsgType: m.typ.String(),
+		MsgId:   m.id,
+		FromKey: m.fromKey,
+		ToKey:   m.toKey,
+		Args:    m.args,
+		Err:     err,
+		Time:    time.Now(),
+	})
+}
+
+// 请求处理失败时的死信原因
+func requestDeadLetterReason(m *msg) DeadLetterReason {
+	switch m.typ {
+	case msgForward, msgRemoteForward, msgKeyRequest:
+		return DeadLetterForwardFailed
+	case msgRemoteNotify:
+		return DeadLetterNotifyFailed
+	}
+	return DeadLetterNoHandler
+}
@@ -0,0 +1,102 @@
+package gproc
+
+import (
+	"testing"
+)
+
+func TestDeadLetters(t *testing.T) {
+	dl := NewDeadLetters(2)
+	old := GetDeadLetters()
+	SetDeadLetters(dl)
+	defer SetDeadLetters(old)
+
+	published := make(chan *DeadLetter, 10)
+	cancel := dl.Subscribe(func(d *DeadLetter) {
+		published <- d
+	})
+	defer cancel()
+
+	service := NewDefaultRequestHandler()
+	service.RegisterHandle(MsgIdGetItemList, func(sender ISender, args interface{}) {
+		sender.Send(MsgIdBuyItem, nil)
+	})
+	go service.Run()
+	defer service.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(service, int32(1))
+
+	// 没有处理函数的请求
+	r.Request(MsgIdIncrCounter, "no handler")
+	d := <-published
+	if d.Reason != DeadLetterNoHandler || d.MsgId != MsgIdIncrCounter || d.FromKey != int32(1) || d.Args != "no handler" {
+		t.Fatalf("unexpected dead letter %+v", d)
+	}
+
+	// 转发到不存在的key
+	r.RequestForward(int32(100), MsgIdChat, nil)
+	d = <-published
+	if d.Reason != DeadLetterForwardFailed || d.ToKey != int32(100) || d.Err != ErrNotFoundNoTargetForwardHandle {
+		t.Fatalf("unexpected dead letter %+v", d)
+	}
+
+	// 没有requester处理的返回
+	r.Request(MsgIdGetItemList, nil)
+	waitUntil(t, func() bool { return len(published) > 0 }, p)
+	d = <-published
+	if d.Reason != DeadLetterUnclaimedResponse || d.MsgId != MsgIdBuyItem {
+		t.Fatalf("unexpected dead letter %+v", d)
+	}
+
+	// 发送到已关闭的处理器
+	p.Close()
+	if err := p.Send(MsgIdChat, nil); err != ErrClosed {
+		t.Fatalf("send to closed expect ErrClosed, got %v", err)
+	}
+	d = <-published
+	if d.Reason != DeadLetterClosed {
+		t.Fatalf("unexpected dead letter %+v", d)
+	}
+
+	if dl.Total() != 4 || dl.Count(DeadLetterNoHandler) != 1 || dl.Count(DeadLetterClosed) != 1 {
+		t.Fatalf("unexpected counts total %v", dl.Total())
+	}
+	recent := dl.Recent()
+	if len(recent) != 2 || recent[0].Reason != DeadLetterUnclaimedResponse || recent[1].Reason != DeadLetterClosed {
+		t.Fatalf("expect 2 recent dead letters, got %v", len(recent))
+	}
+}
+
+func TestDeadLettersMailboxFullAndQueued(t *testing.T) {
+	dl := NewDeadLetters(0)
+	old := GetDeadLetters()
+	SetDeadLetters(dl)
+	defer SetDeadLetters(old)
+
+	service := NewRequestHandler(newHandler(2))
+	for i := 0; i < 3; i++ {
+		m := getMsg()
+		m.typ = msgNormal
+		m.id = MsgIdGetItemList
+		m.args = i
+		m.noWait = true
+		err := service.recv(m)
+		if i < 2 && err != nil {
+			t.Fatalf("recv err: %v", err)
+		}
+		if i == 2 && err != ErrMailboxFull {
+			t.Fatalf("recv to full mailbox expect ErrMailboxFull, got %v", err)
+		}
+	}
+	// 没有处理的消息在关闭时作为死信
+	service.Close()
+	<-service.Done()
+	recent := dl.Recent()
+	if len(recent) != 3 || recent[0].Reason != DeadLetterMailboxFull || recent[0].Args != 2 {
+		t.Fatalf("unexpected dead letters %v", len(recent))
+	}
+	if recent[1].Reason != DeadLetterClosed || recent[1].Args != 0 || recent[2].Reason != DeadLetterClosed || recent[2].Args != 1 {
+		t.Fatalf("queued messages expect closed dead letters, got %+v %+v", recent[1], recent[2])
+	}
+}
@@ -0,0 +1,81 @@
+package gproc
+
+import (
+	"sync"
+)
+
+// 分布式key目录接口，记录每个requester的key所在的节点
+// 转交的消息带着发起者的ISender对象，不能序列化，节点只能是同一进程内的请求处理器
+type IKeyDirectory interface {
+	// 登记key所在的节点
+	Register(key interface{}, node string)
+	// 注销key，只有key仍在node上时才注销
+	Unregister(key interface{}, node string)
+	// 查找key所在的节点和节点的请求处理器
+	Lookup(key interface{}) (node string, handler IRequestHandler, ok bool)
+}
+
+// key目录，进程内实现，多个节点共享同一个目录对象
+type KeyDirectory struct {
+	locker sync.RWMutex
+	keys   map[interface{}]string
+	nodes  map[string]IRequestHandler
+}
+
+// 创建key目录
+func NewKeyDirectory() *KeyDirectory {
+	return &KeyDirectory{
+		keys:  make(map[interface{}]string),
+		nodes: make(map[string]IRequestHandler),
+	}
+}
+
+// 添加节点，handler是节点上负责转发的请求处理器
+func (d *KeyDirectory) AddNode(node string, handler IRequestHandler) {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	d.nodes[node] = handler
+}
+
+// 删除节点，节点上的key一并删除
+func (d *KeyDirectory) RemoveNode(node string) {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	delete(d.nodes, node)
+	for k, n := range d.keys {
+		if n == node {
+			delete(d.keys, k)
+		}
+	}
+}
+
+// 登记key
+func (d *KeyDirectory) Register(key interface{}, node string) {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	d.keys[key] = node
+}
+
+// 注销key
+func (d *KeyDirectory) Unregister(key interface{}, node string) {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	if n, o := d.keys[key]; o && n == node {
+		delete(d.keys, key)
+	}
+}
+
+// 查找key
+func (d *KeyDirectory) Lookup(key interface{}) (string, IRequestHandler, bool) {
+	d.locker.RLock()
+	defer d.locker.RUnlock()
+	node, o := d.keys[key]
+	if !o {
+		return "", nil, false
+	}
+	handler, o := d.nodes[node]
+	if !o {
+		return "", nil, false
+	}
+	return node, handler, true
+}
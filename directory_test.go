@@ -0,0 +1,134 @@
+package gproc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyDirectoryRemoteForward(t *testing.T) {
+	directory := NewKeyDirectory()
+	node1 := NewDefaultRequestHandler()
+	node2 := NewDefaultRequestHandler()
+	node1.SetKeyDirectory(directory, "node1")
+	node2.SetKeyDirectory(directory, "node2")
+	directory.AddNode("node1", node1)
+	directory.AddNode("node2", node2)
+	node1.RegisterHandle(MsgIdUpdateFriendInfo, func(_ ISender, args interface{}) {
+		node1.Notify(int32(2), MsgIdUpdateFriendInfo, args)
+	})
+	go node1.Run()
+	go node2.Run()
+	defer node1.Close()
+	defer node2.Close()
+
+	p1 := NewDefaultResponseHandler()
+	p2 := NewDefaultResponseHandler()
+	defer p1.Close()
+	defer p2.Close()
+	r1 := p1.CreateRequester(node1, int32(1))
+	r2 := p2.CreateRequester(node2, int32(2))
+
+	var chatFrom, ackFrom, notified interface{}
+	r2.RegisterForward(MsgIdChat, func(fromKey interface{}, args interface{}) {
+		chatFrom = fromKey
+		r2.RequestForward(fromKey, MsgIdChatAck, &msgChatAck{message: args.(*msgChat).message})
+	})
+	r1.RegisterForward(MsgIdChatAck, func(fromKey interface{}, args interface{}) {
+		ackFrom = fromKey
+	})
+	r2.RegisterNotify(MsgIdUpdateFriendInfo, func(args interface{}) {
+		notified = args
+	})
+
+	// 等待两个节点都处理完报名
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, _, o := directory.Lookup(int32(1)); o {
+			if _, _, o = directory.Lookup(int32(2)); o {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("sign up not registered in key directory")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := r1.RequestForward(int32(2), MsgIdChat, &msgChat{message: "hello"}); err != nil {
+		t.Fatalf("request forward err: %v", err)
+	}
+	for ackFrom == nil && time.Now().Before(deadline) {
+		p1.Update()
+		p2.Update()
+		time.Sleep(time.Millisecond)
+	}
+	if chatFrom != int32(1) || ackFrom != int32(2) {
+		t.Fatalf("expect chat from 1 and ack from 2, got %v and %v", chatFrom, ackFrom)
+	}
+
+	// 通知也能转交到其他节点
+	if err := r1.Request(MsgIdUpdateFriendInfo, "info"); err != nil {
+		t.Fatalf("request err: %v", err)
+	}
+	for notified == nil && time.Now().Before(deadline) {
+		p2.Update()
+		time.Sleep(time.Millisecond)
+	}
+	if notified != "info" {
+		t.Fatalf("expect notified info, got %v", notified)
+	}
+}
+
+func TestKeyDirectoryRelayMailboxFull(t *testing.T) {
+	dl := NewDeadLetters(0)
+	old := GetDeadLetters()
+	SetDeadLetters(dl)
+	defer SetDeadLetters(old)
+	published := make(chan *DeadLetter, 10)
+	cancel := dl.Subscribe(func(d *DeadLetter) {
+		if d.Reason == DeadLetterForwardFailed || d.Reason == DeadLetterMailboxFull {
+			published <- d
+		}
+	})
+	defer cancel()
+
+	directory := NewKeyDirectory()
+	node1 := NewDefaultRequestHandler()
+	// node2不运行，通道已满
+	node2 := NewRequestHandler(newHandler(1))
+	node1.SetKeyDirectory(directory, "node1")
+	node2.SetKeyDirectory(directory, "node2")
+	directory.AddNode("node1", node1)
+	directory.AddNode("node2", node2)
+	directory.Register(int32(2), "node2")
+	m := getMsg()
+	m.typ = msgExec
+	m.args = func() {}
+	node2.recv(m)
+	go node1.Run()
+	defer node1.Close()
+	defer node2.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(node1, int32(1))
+	// 转交不阻塞node1的处理循环
+	r.RequestForward(int32(2), MsgIdChat, nil)
+	select {
+	case d := <-published:
+		if d.Reason != DeadLetterMailboxFull || d.MsgType != "remote_forward" || d.ToKey != int32(2) {
+			t.Fatalf("unexpected dead letter %+v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("relay forward to full mailbox expect dead letter")
+	}
+	if d := <-published; d.Reason != DeadLetterForwardFailed || d.Err != ErrMailboxFull {
+		t.Fatalf("unexpected dead letter %+v", d)
+	}
+	if err := node1.Notify(int32(2), MsgIdUpdateFriendInfo, nil); err != ErrMailboxFull {
+		t.Fatalf("relay notify expect ErrMailboxFull, got %v", err)
+	}
+	if d := <-published; d.Reason != DeadLetterMailboxFull || d.MsgType != "remote_notify" || d.ToKey != int32(2) {
+		t.Fatalf("unexpected dead letter %+v", d)
+	}
+}
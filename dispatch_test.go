@@ -0,0 +1,71 @@
+package gproc
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// LocalService按消息类型区分请求和返回，同一个msgId的请求和返回不会混淆，消息只回收一次
+func TestLocalServiceDispatchByKind(t *testing.T) {
+	dl := NewDeadLetters(0)
+	old := GetDeadLetters()
+	SetDeadLetters(dl)
+	defer SetDeadLetters(old)
+
+	// 邮箱容纳全部消息，避免A和B互相阻塞在满的通道上
+	count := 500
+	b := NewLocalService(int32(count * 2))
+	b.RegisterHandle(MsgIdGetItemList, func(sender ISender, args interface{}) {
+		sender.Send(MsgIdGetItemList, args)
+	})
+
+	a := NewLocalService(int32(count * 4))
+	var aHandled int32
+	var waiting []ISender
+	a.RegisterHandle(MsgIdGetItemList, func(sender ISender, args interface{}) {
+		atomic.AddInt32(&aHandled, 1)
+	})
+	toB := a.NewRequester(b, "a")
+	a.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+		waiting = append(waiting, sender)
+		toB.Request(MsgIdGetItemList, args)
+	})
+	// B的返回与A的请求处理函数是同一个msgId，必须交给回调
+	toB.RegisterCallback(MsgIdGetItemList, func(args interface{}) {
+		sender := waiting[0]
+		waiting = waiting[1:]
+		sender.Send(MsgIdBuyItem, args)
+	})
+
+	go a.Run()
+	go b.Run()
+	defer a.Close()
+	defer b.Close()
+
+	p := NewResponseHandler(newHandler(int32(count * 2)))
+	defer p.Close()
+	r := p.CreateRequester(a, "player")
+	recvd := 0
+	r.RegisterCallback(MsgIdBuyItem, func(args interface{}) {
+		if args.(int) != recvd {
+			t.Errorf("expect response %v, got %v", recvd, args)
+		}
+		recvd += 1
+	})
+
+	for i := 0; i < count; i++ {
+		r.Request(MsgIdBuyItem, i)
+		// 没有处理函数的请求只作为死信，不会交给返回处理
+		r.Request(MsgIdIncrCounter, i)
+		p.Update()
+	}
+	waitUntil(t, func() bool { return recvd == count }, p)
+
+	if n := atomic.LoadInt32(&aHandled); n != 0 {
+		t.Fatalf("reply dispatched to request handle %v times", n)
+	}
+	waitUntil(t, func() bool { return dl.Count(DeadLetterNoHandler) == uint64(count) })
+	if n := dl.Count(DeadLetterUnclaimedResponse); n != 0 {
+		t.Fatalf("expect no unclaimed response, got %v", n)
+	}
+}
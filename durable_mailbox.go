@@ -0,0 +1,288 @@
+package gproc
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	DurableMailboxCompactRecords = 1024 // 记录数超过这个值且一半以上已确认时重写日志，只保留没有确认的消息
+)
+
+// 预写日志的记录类型
+const (
+	walRecordMsg uint32 = 1 // 消息
+	walRecordAck uint32 = 2 // 确认处理完成
+)
+
+// 预写日志中的消息，参数用编解码注册表编码
+type walEnvelope struct {
+	MsgId    uint32
+	ArgsType uint32
+	Args     []byte
+	Headers  Headers
+}
+
+// 持久邮箱，请求消息进入通道之前先追加到磁盘上的预写日志，处理函数返回后追加确认
+// 重启时没有确认的消息按顺序重放，保证至少处理一次
+// 只记录普通请求，参数类型必须在编解码注册表中注册，sender和fromKey不记录，重放时sender丢弃所有返回
+type DurableMailbox struct {
+	locker   sync.Mutex
+	path     string
+	file     *os.File
+	registry *CodecRegistry
+	sync     bool
+	seq      uint64
+	unacked  map[uint64][]byte // 没有确认的消息记录，重写日志时保留
+	records  int               // 日志中的记录数
+	pending  []JournalRecord
+}
+
+// 打开持久邮箱，registry为nil时使用全局编解码注册表，sync为true时每次追加后同步到磁盘
+func OpenDurableMailbox(path string, registry *CodecRegistry, sync bool) (*DurableMailbox, error) {
+	if registry == nil {
+		registry = GetCodecRegistry()
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	b := &DurableMailbox{
+		path:     path,
+		file:     f,
+		registry: registry,
+		sync:     sync,
+		unacked:  make(map[uint64][]byte),
+	}
+	if err := b.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// 读取日志，找出没有确认的消息，截掉不完整的尾部记录，中间损坏的记录返回ErrJournalCorrupted
+func (b *DurableMailbox) load() error {
+	msgs := make(map[uint64]JournalRecord)
+	valid, err := scanJournal(b.file, func(record JournalRecord) error {
+		b.records += 1
+		if record.Seq > b.seq {
+			b.seq = record.Seq
+		}
+		switch record.TypeId {
+		case walRecordMsg:
+			msgs[record.Seq] = record
+		case walRecordAck:
+			delete(msgs, record.Seq)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := b.file.Truncate(valid); err != nil {
+		return err
+	}
+	if _, err := b.file.Seek(valid, io.SeekStart); err != nil {
+		return err
+	}
+	for seq, record := range msgs {
+		b.unacked[seq] = encodeJournalRecord(record)
+		b.pending = append(b.pending, record)
+	}
+	sort.Slice(b.pending, func(i, j int) bool {
+		return b.pending[i].Seq < b.pending[j].Seq
+	})
+	return nil
+}
+
+// 没有确认的消息数
+func (b *DurableMailbox) Pending() int {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	return len(b.unacked)
+}
+
+// 关闭
+func (b *DurableMailbox) Close() error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	return b.file.Close()
+}
+
+// 追加消息，成功后消息带上日志中的序号
+func (b *DurableMailbox) append(m *msg) error {
+	argsType, args, err := b.registry.Encode(m.args)
+	if err != nil {
+		return err
+	}
+	data, err := GobCodec{}.Marshal(&walEnvelope{MsgId: m.id, ArgsType: argsType, Args: args, Headers: m.headers})
+	if err != nil {
+		return err
+	}
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	seq := b.seq + 1
+	record := encodeJournalRecord(JournalRecord{Seq: seq, TypeId: walRecordMsg, Data: data})
+	if err := b.write(record); err != nil {
+		return err
+	}
+	b.seq = seq
+	b.unacked[seq] = record
+	m.walSeq = seq
+	return nil
+}
+
+// 确认消息处理完成，记录足够多且一半以上已确认时重写日志
+func (b *DurableMailbox) ack(seq uint64) error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	if _, o := b.unacked[seq]; !o {
+		return nil
+	}
+	if err := b.write(encodeJournalRecord(JournalRecord{Seq: seq, TypeId: walRecordAck})); err != nil {
+		return err
+	}
+	delete(b.unacked, seq)
+	if b.records >= DurableMailboxCompactRecords && b.records >= 2*len(b.unacked) {
+		return b.compact()
+	}
+	return nil
+}
+
+// 重写日志，只保留没有确认的消息，先写临时文件再改名，调用者加锁
+// 持续有消息进入时也能压缩，不需要等邮箱空闲
+func (b *DurableMailbox) compact() error {
+	seqs := make([]uint64, 0, len(b.unacked))
+	for seq := range b.unacked {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i] < seqs[j]
+	})
+	tmp := b.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, seq := range seqs {
+		if _, err = w.Write(b.unacked[seq]); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp, b.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	file, err := os.OpenFile(b.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return err
+	}
+	b.file.Close()
+	b.file = file
+	b.records = len(seqs)
+	return nil
+}
+
+// 写入编码后的记录，调用者加锁
+func (b *DurableMailbox) write(record []byte) error {
+	if _, err := b.file.Write(record); err != nil {
+		return err
+	}
+	b.records += 1
+	if b.sync {
+		return b.file.Sync()
+	}
+	return nil
+}
+
+// 取出重启前没有确认的消息，只能取一次
+func (b *DurableMailbox) recover() ([]*msg, error) {
+	b.locker.Lock()
+	pending := b.pending
+	b.pending = nil
+	b.locker.Unlock()
+	msgs := make([]*msg, 0, len(pending))
+	for _, record := range pending {
+		var envelope walEnvelope
+		if err := (GobCodec{}).Unmarshal(record.Data, &envelope); err != nil {
+			return nil, err
+		}
+		args, err := b.registry.Decode(envelope.ArgsType, envelope.Args)
+		if err != nil {
+			return nil, err
+		}
+		m := getMsg()
+		m.typ = msgNormal
+		m.sender = recoveredSender{}
+		m.id = envelope.MsgId
+		m.args = args
+		m.headers = envelope.Headers
+		m.walSeq = record.Seq
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
+
+// 重放消息的发送者，原来的发送者已经不存在，返回都被丢弃
+type recoveredSender struct{}
+
+// 丢弃返回
+func (recoveredSender) Send(msgId uint32, args interface{}, options ...SendOption) error {
+	return nil
+}
+
+// 丢弃转发
+func (recoveredSender) send(m *msg) error {
+	putMsg(m)
+	return nil
+}
+
+// 是否是重启后重放的消息的发送者
+func IsRecoveredSender(sender ISender) bool {
+	_, o := sender.(recoveredSender)
+	return o
+}
+
+// 设置持久邮箱，在Run之前调用，重启前没有处理完的消息在处理邮箱中的消息之前重放
+func (h *RequestHandler) SetDurableMailbox(mailbox *DurableMailbox) error {
+	msgs, err := mailbox.recover()
+	if err != nil {
+		return err
+	}
+	h.mailbox = mailbox
+	if len(msgs) == 0 {
+		return nil
+	}
+	h.unstashed = append(h.unstashed, msgs...)
+	// 投递一条空的执行消息，让处理循环开始重放
+	m := getMsg()
+	m.typ = msgExec
+	m.args = func() {}
+	return h.handler.Send(m)
+}
+
+// 设置持久邮箱
+func (s *LocalService) SetDurableMailbox(mailbox *DurableMailbox) error {
+	return s.requestHandler.SetDurableMailbox(mailbox)
+}
@@ -0,0 +1,162 @@
+package gproc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type payOrder struct {
+	OrderId string
+	Amount  int32
+}
+
+func newPayRegistry() *CodecRegistry {
+	registry := NewCodecRegistry(nil)
+	registry.Register(1, "PayOrder", &payOrder{})
+	return registry
+}
+
+// 处理中崩溃后，没有处理完的支付请求在重启后按顺序重放
+func TestDurableMailboxReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pay.wal")
+	mailbox, err := OpenDurableMailbox(path, newPayRegistry(), true)
+	if err != nil {
+		t.Fatalf("open durable mailbox err: %v", err)
+	}
+
+	// 第一次运行，处理第二个订单时卡住，模拟进程崩溃
+	stuck := make(chan struct{})
+	defer close(stuck)
+	service := NewDefaultLocalService()
+	if err := service.SetDurableMailbox(mailbox); err != nil {
+		t.Fatalf("set durable mailbox err: %v", err)
+	}
+	service.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+		order := args.(*payOrder)
+		if order.OrderId == "b" {
+			<-stuck
+			return
+		}
+		sender.Send(MsgIdBuyItem, order.OrderId)
+	})
+	go service.Run()
+	defer service.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(service, "payer")
+	var paid []interface{}
+	r.RegisterCallback(MsgIdBuyItem, func(args interface{}) {
+		paid = append(paid, args)
+	})
+	if err := r.Request(MsgIdBuyItem, "not registered"); err != ErrCodecTypeNotRegistered {
+		t.Fatalf("expect ErrCodecTypeNotRegistered, got %v", err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := r.Request(MsgIdBuyItem, &payOrder{OrderId: id, Amount: 10}); err != nil {
+			t.Fatalf("request err: %v", err)
+		}
+	}
+	waitUntil(t, func() bool { return len(paid) == 1 && mailbox.Pending() == 2 }, p)
+	mailbox.Close()
+
+	// 重启
+	mailbox, err = OpenDurableMailbox(path, newPayRegistry(), true)
+	if err != nil {
+		t.Fatalf("reopen durable mailbox err: %v", err)
+	}
+	defer mailbox.Close()
+	if n := mailbox.Pending(); n != 2 {
+		t.Fatalf("expect 2 pending after restart, got %v", n)
+	}
+	restarted := NewDefaultLocalService()
+	var replayed []string
+	restarted.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+		if !IsRecoveredSender(sender) {
+			t.Errorf("expect recovered sender")
+		}
+		order := args.(*payOrder)
+		if order.Amount != 10 {
+			t.Errorf("unexpected order %+v", order)
+		}
+		replayed = append(replayed, order.OrderId)
+		sender.Send(MsgIdBuyItem, order.OrderId)
+	})
+	if err := restarted.SetDurableMailbox(mailbox); err != nil {
+		t.Fatalf("set durable mailbox err: %v", err)
+	}
+	go restarted.Run()
+	defer restarted.Close()
+	waitUntil(t, func() bool { return mailbox.Pending() == 0 })
+	restarted.Close()
+	<-restarted.Done()
+	if len(replayed) != 2 || replayed[0] != "b" || replayed[1] != "c" {
+		t.Fatalf("unexpected replayed %v", replayed)
+	}
+}
+
+// 一直有没有确认的消息时日志也会压缩，重启后没有确认的消息还在
+func TestDurableMailboxCompactUnderLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pay.wal")
+	mailbox, err := OpenDurableMailbox(path, newPayRegistry(), false)
+	if err != nil {
+		t.Fatalf("open durable mailbox err: %v", err)
+	}
+	var last *msg
+	for i := 0; i < DurableMailboxCompactRecords*4; i++ {
+		m := getMsg()
+		m.id = MsgIdBuyItem
+		m.args = &payOrder{OrderId: fmt.Sprint(i), Amount: 1}
+		if err := mailbox.append(m); err != nil {
+			t.Fatalf("append err: %v", err)
+		}
+		if last != nil {
+			if err := mailbox.ack(last.walSeq); err != nil {
+				t.Fatalf("ack err: %v", err)
+			}
+			putMsg(last)
+		}
+		last = m
+	}
+	mailbox.Close()
+	if n := mailbox.records; n > DurableMailboxCompactRecords {
+		t.Fatalf("journal not compacted, %v records", n)
+	}
+
+	mailbox, err = OpenDurableMailbox(path, newPayRegistry(), false)
+	if err != nil {
+		t.Fatalf("reopen durable mailbox err: %v", err)
+	}
+	defer mailbox.Close()
+	msgs, err := mailbox.recover()
+	if err != nil || len(msgs) != 1 || msgs[0].args.(*payOrder).OrderId != fmt.Sprint(DurableMailboxCompactRecords*4-1) {
+		t.Fatalf("unexpected recovered %v %v", msgs, err)
+	}
+}
+
+// 中间损坏的记录不截断后面没有确认的消息，打开返回ErrJournalCorrupted
+func TestDurableMailboxCorruptedMiddle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pay.wal")
+	mailbox, _ := OpenDurableMailbox(path, newPayRegistry(), false)
+	for _, id := range []string{"a", "b", "c"} {
+		m := getMsg()
+		m.id = MsgIdBuyItem
+		m.args = &payOrder{OrderId: id}
+		mailbox.append(m)
+		putMsg(m)
+	}
+	mailbox.Close()
+
+	content, _ := os.ReadFile(path)
+	size := len(content)
+	content[20] ^= 0xff
+	os.WriteFile(path, content, 0644)
+	if _, err := OpenDurableMailbox(path, newPayRegistry(), false); err != ErrJournalCorrupted {
+		t.Fatalf("expect ErrJournalCorrupted, got %v", err)
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(size) {
+		t.Fatalf("journal truncated to %v", info.Size())
+	}
+}
@@ -5,3 +5,27 @@ import "errors"
 var ErrClosed = errors.New("gproc: closed service cant request")
 var ErrNotFoundRequesterKey = errors.New("gproc: not found requester key")
 var ErrNotFoundNoTargetForwardHandle = errors.New("gproc: not found no target forward handle")
+var ErrRegistryNilHandler = errors.New("gproc: registry cant register nil handler")
+var ErrRegistryNameExists = errors.New("gproc: registry name already registered by other node")
+var ErrRegistryNameNotFound = errors.New("gproc: registry name not found")
+var ErrActorKindNotFound = errors.New("gproc: actor kind not registered")
+var ErrServiceScheduled = errors.New("gproc: service is executed by scheduler")
+var ErrNotFoundRequestHandle = errors.New("gproc: not found request handle")
+var ErrUnknownMsgType = errors.New("gproc: unknown msg type")
+var ErrAlreadyRunning = errors.New("gproc: handler already running")
+var ErrMailboxFull = errors.New("gproc: mailbox full")
+var ErrRequestTimeout = errors.New("gproc: request timeout")
+var ErrCircuitOpen = errors.New("gproc: circuit breaker is open")
+var ErrNotStreamRequest = errors.New("gproc: sender is not a stream request")
+var ErrStreamClosed = errors.New("gproc: stream closed")
+var ErrStreamNoCredit = errors.New("gproc: stream has no credit")
+var ErrWorkerPoolFull = errors.New("gproc: worker pool queue full")
+var ErrStashNoMessage = errors.New("gproc: no request message to stash")
+var ErrCodecInvalidType = errors.New("gproc: codec cant register nil prototype or zero id")
+var ErrCodecIdExists = errors.New("gproc: codec id already registered by other type")
+var ErrCodecTypeNotRegistered = errors.New("gproc: codec type not registered")
+var ErrJournalCorrupted = errors.New("gproc: journal record corrupted")
+var ErrPersistenceNotInit = errors.New("gproc: service persistence not initialized")
+var ErrSnapshotNotRegistered = errors.New("gproc: persistence snapshot not registered")
+var ErrSteppingMailboxFull = errors.New("gproc: mailbox full while stepping, send would block forever")
+var ErrKeyOwnerCantHandleRequest = errors.New("gproc: key owner cant handle requests")
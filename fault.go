@@ -0,0 +1,282 @@
+package gproc
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	FaultDelayDuration   = time.Duration(100 * time.Millisecond) // 默认的延迟时间
+	FaultReorderDuration = time.Duration(100 * time.Millisecond) // 默认的乱序最长暂扣时间
+)
+
+// 故障动作
+type FaultAction int32
+
+const (
+	FaultDrop      FaultAction = iota // 丢弃，发送方得到成功
+	FaultDelay                        // 延迟Delay后投递
+	FaultDuplicate                    // 投递两次
+	FaultReorder                      // 暂扣到下一条消息之后投递，最多暂扣Delay
+)
+
+var faultActionNames = []string{
+	FaultDrop:      "drop",
+	FaultDelay:     "delay",
+	FaultDuplicate: "duplicate",
+	FaultReorder:   "reorder",
+}
+
+func (a FaultAction) String() string {
+	if a < 0 || int(a) >= len(faultActionNames) {
+		return "unknown"
+	}
+	return faultActionNames[a]
+}
+
+// 故障规则，条件都满足时按概率生效
+type FaultRule struct {
+	Action      FaultAction
+	MsgIds      []uint32      // 匹配的消息id，空表示所有
+	Keys        []interface{} // 匹配消息的fromKey或toKey，空表示所有，返回消息没有key
+	Probability float64       // 生效概率，<=0或>=1表示总是生效
+	Delay       time.Duration // 延迟时间或乱序的最长暂扣时间，<=0时用默认值
+}
+
+// 是否匹配消息
+func (r *FaultRule) match(m *msg) bool {
+	if len(r.MsgIds) > 0 {
+		found := false
+		for _, id := range r.MsgIds {
+			if id == m.id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.Keys) > 0 {
+		found := false
+		for _, k := range r.Keys {
+			if (m.fromKey != nil && k == m.fromKey) || (m.toKey != nil && k == m.toKey) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// 故障注入统计
+type FaultStats struct {
+	Dropped    uint64
+	Delayed    uint64
+	Duplicated uint64
+	Reordered  uint64
+}
+
+// 乱序暂扣的消息
+type faultHeld struct {
+	m     *msg
+	timer *time.Timer
+}
+
+// 故障注入器，设置到服务或返回处理器后在消息进入通道前按规则丢弃、延迟、重复或乱序，用于混沌测试
+// 只影响请求、转发、通知和返回，报名、注销和函数执行消息不受影响
+// 规则按添加顺序匹配，第一条条件满足且概率命中的规则生效，相同的种子和消息顺序得到相同的决定
+// 延迟和乱序使用系统时间，多个处理器可以共用一个注入器
+type FaultInjector struct {
+	locker  sync.Mutex
+	rand    *rand.Rand
+	rules   []FaultRule
+	enabled bool
+	held    map[*handler]*faultHeld
+	stats   FaultStats
+}
+
+// 创建故障注入器
+func NewFaultInjector(seed int64) *FaultInjector {
+	return &FaultInjector{
+		rand:    rand.New(rand.NewSource(seed)),
+		enabled: true,
+		held:    make(map[*handler]*faultHeld),
+	}
+}
+
+// 添加规则
+func (f *FaultInjector) AddRule(rule FaultRule) {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	f.rules = append(f.rules, rule)
+}
+
+// 替换所有规则
+func (f *FaultInjector) SetRules(rules ...FaultRule) {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	f.rules = append([]FaultRule(nil), rules...)
+}
+
+// 清除所有规则
+func (f *FaultInjector) ClearRules() {
+	f.SetRules()
+}
+
+// 开启或暂停注入，暂停时暂扣的消息仍然按时投递
+func (f *FaultInjector) SetEnabled(enabled bool) {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	f.enabled = enabled
+}
+
+// 重新设置随机种子
+func (f *FaultInjector) Seed(seed int64) {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	f.rand.Seed(seed)
+}
+
+// 统计
+func (f *FaultInjector) Stats() FaultStats {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	return f.stats
+}
+
+// 选择生效的规则，需要持有锁
+func (f *FaultInjector) choose(m *msg) (FaultRule, bool) {
+	if !f.enabled {
+		return FaultRule{}, false
+	}
+	for i := range f.rules {
+		r := &f.rules[i]
+		if !r.match(m) {
+			continue
+		}
+		if r.Probability > 0 && r.Probability < 1 && f.rand.Float64() >= r.Probability {
+			continue
+		}
+		return *r, true
+	}
+	return FaultRule{}, false
+}
+
+// 注入故障并投递，控制消息返回false，由调用者投递
+func (f *FaultInjector) inject(h *handler, m *msg) (bool, error) {
+	if m.typ != msgNormal && m.typ != msgForward && m.typ != msgRemoteForward && m.typ != msgRemoteNotify && m.typ != msgKeyRequest && !m.typ.isResponse() {
+		return false, nil
+	}
+	f.locker.Lock()
+	// 有暂扣的消息时，在这条消息之后投递，这条消息不再暂扣
+	held := f.held[h]
+	if held != nil {
+		delete(f.held, h)
+		held.timer.Stop()
+		defer deliverLater(h, held.m)
+	}
+	rule, o := f.choose(m)
+	if !o {
+		f.locker.Unlock()
+		return true, h.deliver(m)
+	}
+	delay := rule.Delay
+	switch rule.Action {
+	case FaultDrop:
+		f.stats.Dropped += 1
+		f.locker.Unlock()
+		putMsg(m)
+		return true, nil
+	case FaultDelay:
+		f.stats.Delayed += 1
+		f.locker.Unlock()
+		if delay <= 0 {
+			delay = FaultDelayDuration
+		}
+		time.AfterFunc(delay, func() {
+			deliverLater(h, m)
+		})
+		return true, nil
+	case FaultDuplicate:
+		f.stats.Duplicated += 1
+		f.locker.Unlock()
+		// 投递后消息可能已被处理和回收，先复制，复制的不再确认持久邮箱
+		c := getMsg()
+		*c = *m
+		c.walSeq = 0
+		err := h.deliver(m)
+		if err == nil {
+			deliverLater(h, c)
+		} else {
+			putMsg(c)
+		}
+		return true, err
+	case FaultReorder:
+		if held != nil {
+			break
+		}
+		f.stats.Reordered += 1
+		if delay <= 0 {
+			delay = FaultReorderDuration
+		}
+		held = &faultHeld{m: m}
+		held.timer = time.AfterFunc(delay, func() {
+			f.release(h, held)
+		})
+		f.held[h] = held
+		f.locker.Unlock()
+		return true, nil
+	}
+	f.locker.Unlock()
+	return true, h.deliver(m)
+}
+
+// 暂扣超时，直接投递
+func (f *FaultInjector) release(h *handler, held *faultHeld) {
+	f.locker.Lock()
+	if f.held[h] != held {
+		f.locker.Unlock()
+		return
+	}
+	delete(f.held, h)
+	f.locker.Unlock()
+	deliverLater(h, held.m)
+}
+
+// 投递延迟、暂扣或者复制的消息，发送者已经返回，投递失败时deliver发布了死信，消息在这里回收
+func deliverLater(h *handler, m *msg) {
+	if err := h.deliver(m); err != nil {
+		putMsg(m)
+	}
+}
+
+// 设置故障注入器，nil表示取消，可以在运行中设置
+func (h *handler) setFaultInjector(f *FaultInjector) {
+	h.faults.Store(f)
+}
+
+// 当前的故障注入器
+func (h *handler) faultInjector() *FaultInjector {
+	f, _ := h.faults.Load().(*FaultInjector)
+	return f
+}
+
+// 设置故障注入器，请求和返回都会受影响
+func (s *LocalService) SetFaultInjector(f *FaultInjector) {
+	s.handler.setFaultInjector(f)
+}
+
+// 设置故障注入器
+func (h *RequestHandler) SetFaultInjector(f *FaultInjector) {
+	h.handler.setFaultInjector(f)
+}
+
+// 设置故障注入器
+func (h *ResponseHandler) SetFaultInjector(f *FaultInjector) {
+	h.handler.setFaultInjector(f)
+}
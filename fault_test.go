@@ -0,0 +1,139 @@
+package gproc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFaultDropRequest(t *testing.T) {
+	service := NewDefaultLocalService()
+	service.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+		sender.Send(MsgIdBuyItem, args)
+	})
+	faults := NewFaultInjector(1)
+	faults.AddRule(FaultRule{Action: FaultDrop, Keys: []interface{}{int32(1)}})
+	service.SetFaultInjector(faults)
+	go service.Run()
+	defer service.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(service, int32(1), RequestTimeout(20), RequestFailHandle(func(msgId uint32, args interface{}, err error) {
+		if err != ErrRequestTimeout {
+			t.Errorf("expect request timeout, got %v", err)
+		}
+	}))
+	var resps []interface{}
+	r.RegisterCallback(MsgIdBuyItem, func(args interface{}) {
+		resps = append(resps, args)
+	})
+	r.Request(MsgIdBuyItem, 1)
+	waitUntil(t, func() bool { return faults.Stats().Dropped == 1 }, p)
+	time.Sleep(time.Millisecond * 40)
+	p.Update()
+	if len(resps) != 0 {
+		t.Fatalf("dropped request expect no response, got %v", resps)
+	}
+
+	// 运行中清除规则后恢复正常
+	faults.ClearRules()
+	r.Request(MsgIdBuyItem, 2)
+	waitUntil(t, func() bool { return len(resps) == 1 }, p)
+	if resps[0] != 2 {
+		t.Fatalf("expect response 2, got %v", resps)
+	}
+}
+
+func TestFaultReorderAndDuplicateReply(t *testing.T) {
+	service := NewDefaultRequestHandler()
+	service.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+		// 通知不带请求序号，都由回调处理
+		for i := 1; i <= 3; i++ {
+			service.Notify(int32(1), MsgIdBuyItem, i)
+		}
+		service.Notify(int32(1), MsgIdUpdateFriendInfo, 0)
+	})
+	go service.Run()
+	defer service.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	faults := NewFaultInjector(1)
+	faults.SetRules(
+		FaultRule{Action: FaultReorder, MsgIds: []uint32{MsgIdBuyItem}, Delay: time.Millisecond * 20},
+		FaultRule{Action: FaultDuplicate, MsgIds: []uint32{MsgIdUpdateFriendInfo}},
+	)
+	p.SetFaultInjector(faults)
+	r := p.CreateRequester(service, int32(1))
+	var resps []interface{}
+	r.RegisterCallback(MsgIdBuyItem, func(args interface{}) {
+		resps = append(resps, args)
+	})
+	acks := 0
+	r.RegisterCallback(MsgIdUpdateFriendInfo, func(args interface{}) {
+		acks += 1
+	})
+	r.Request(MsgIdBuyItem, nil)
+	waitUntil(t, func() bool { return len(resps) == 3 && acks == 2 }, p)
+	// 1被暂扣到2之后，3被暂扣到重复的确认之后
+	if resps[0] != 2 || resps[1] != 1 || resps[2] != 3 {
+		t.Fatalf("expect reordered responses [2 1 3], got %v", resps)
+	}
+	if stats := faults.Stats(); stats.Reordered != 2 || stats.Duplicated != 1 {
+		t.Fatalf("unexpected fault stats %+v", stats)
+	}
+}
+
+func TestFaultSeedReproducible(t *testing.T) {
+	decide := func(seed int64) []bool {
+		f := NewFaultInjector(seed)
+		f.AddRule(FaultRule{Action: FaultDrop, Probability: 0.5})
+		m := &msg{typ: msgNormal, id: MsgIdBuyItem}
+		var result []bool
+		for i := 0; i < 100; i++ {
+			_, o := f.choose(m)
+			result = append(result, o)
+		}
+		return result
+	}
+	a, b := decide(42), decide(42)
+	hits := 0
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("same seed expect same decisions, differ at %v", i)
+		}
+		if a[i] {
+			hits += 1
+		}
+	}
+	if hits == 0 || hits == len(a) {
+		t.Fatalf("probability 0.5 expect mixed decisions, got %v hits", hits)
+	}
+}
+
+func TestFaultDelayAfterClose(t *testing.T) {
+	dl := NewDeadLetters(10)
+	old := GetDeadLetters()
+	SetDeadLetters(dl)
+	defer SetDeadLetters(old)
+
+	service := NewDefaultLocalService()
+	faults := NewFaultInjector(1)
+	faults.AddRule(FaultRule{Action: FaultDelay, Delay: time.Millisecond * 10, Keys: []interface{}{int32(1)}})
+	service.SetFaultInjector(faults)
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(service, int32(1))
+	r.Request(MsgIdBuyItem, 1)
+	// 延迟的请求到期时服务已经关闭，作为死信
+	service.Close()
+	waitUntil(t, func() bool {
+		for _, d := range dl.Recent() {
+			if d.Reason == DeadLetterClosed && d.MsgType == "normal" && d.Args == 1 {
+				return true
+			}
+		}
+		return false
+	})
+}
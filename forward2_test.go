@@ -2,11 +2,7 @@ package gproc
 
 import (
 	"fmt"
-	"math/rand"
-	"sync"
-	"sync/atomic"
 	"testing"
-	"time"
 )
 
 /********************** 消息 *********************/
@@ -242,77 +238,52 @@ func CreateFriendService() *FriendService {
 
 /********************************************************************/
 
-func randPlayerId(selfId int32, playerIds []int32) int32 {
-	if len(playerIds) == 0 {
-		fmt.Println("Player ", selfId, " no friends")
-		return -1
-	}
-	r := rand.Int31n(int32(len(playerIds)))
-	if playerIds[r] == selfId {
-		r += 1
-		if int(r) == len(playerIds) {
-			r = 0
-		}
-	}
-	return playerIds[r]
-}
-
+// 好友服务测试，逐步执行，每个玩家依次加下一个玩家为好友，再删除第一个好友
 func TestFriendService(t *testing.T) {
 	fs := CreateFriendService()
+	fs.SetStepping(true)
 	defer fs.Close()
-	go fs.Run()
 
 	playerCount := int32(4)
 	players := make([]*Player, playerCount)
-	var wg sync.WaitGroup
-	wg.Add(int(playerCount))
+	targets := []stepper{fs}
 	for id := int32(1); id <= playerCount; id++ {
 		p := CreatePlayer(id, 1, fs)
+		p.SetStepping(true)
 		defer p.Close()
 		p.updateFriendInfo()
 		players[id-1] = p
+		targets = append(targets, p)
+	}
+	stepUntilIdle(targets...)
+	if len(fs.PlayerIds) != int(playerCount) {
+		t.Fatalf("expect %v players, got %v", playerCount, fs.PlayerIds)
 	}
 
-	rand.Seed(time.Now().UnixNano())
-
-	count := int32(1)
-	for id := int32(1); id <= playerCount; id++ {
-		p := players[id-1]
-		go func(p *Player) {
-			state := 0
-			for state != 4 {
-				switch p.step {
-				case 0:
-					// 获得推荐列表
-					if state == 0 {
-						p.getFriendRecommendationList()
-						state = 1
-					}
-				case 1:
-					if state == 1 {
-						pid := randPlayerId(p.id, fs.PlayerIds)
-						p.addFriendReq(pid)
-						state = 2
-					}
-				case 2:
-					if state == 2 {
-						pid := randPlayerId(p.id, p.friendList)
-						p.removeFriendReq(pid)
-						state = 3
-					}
-				case 3:
-					if state == 3 {
-						wg.Done()
-						state = 4
-						atomic.AddInt32(&count, 1)
-						fmt.Println("wgDone ", count)
-					}
-				}
-				p.Update()
-				time.Sleep(time.Millisecond)
+	// 每个阶段一个玩家发起后处理到空闲，玩家的步骤前进一步
+	phases := []func(p *Player){
+		func(p *Player) {
+			p.getFriendRecommendationList()
+		},
+		func(p *Player) {
+			p.addFriendReq(p.id%playerCount + 1)
+		},
+		func(p *Player) {
+			p.removeFriendReq(p.friendList[0])
+		},
+	}
+	for i, phase := range phases {
+		for _, p := range players {
+			phase(p)
+			stepUntilIdle(targets...)
+			if p.step != i+1 {
+				t.Fatalf("player %v expect step %v, got %v", p.id, i+1, p.step)
 			}
-		}(p)
+		}
+	}
+	for _, p := range players {
+		if len(p.friendList) != 0 {
+			t.Fatalf("player %v friends not removed: %v", p.id, p.friendList)
+		}
 	}
-
-	wg.Wait()
 }
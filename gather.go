@@ -0,0 +1,154 @@
+package gproc
+
+import (
+	"time"
+)
+
+const (
+	GatherTimeout = time.Duration(5 * time.Second) // 默认的聚合请求超时
+)
+
+// 聚合请求中单个目标的结果
+type GatherResult struct {
+	Target IRequestHandler // 目标，GatherKeys解析失败时为nil
+	Key    interface{}     // 目标的key，只有GatherKeys才有
+	MsgId  uint32          // 返回的消息id
+	Args   interface{}     // 返回的数据
+	Err    error           // 发送失败、解析失败或者超时的错误
+}
+
+// key解析成目标
+type GatherResolver func(key interface{}) (IRequestHandler, error)
+
+// 用key目录解析key
+func KeyDirectoryResolver(directory IKeyDirectory) GatherResolver {
+	return func(key interface{}) (IRequestHandler, error) {
+		_, target, o := directory.Lookup(key)
+		if !o {
+			return nil, ErrNotFoundRequesterKey
+		}
+		return target, nil
+	}
+}
+
+// 进行中的聚合请求
+type gatherCall struct {
+	results []GatherResult
+	pending []*pendingRequest
+	remain  int
+	timer   *Timer
+	done    func([]GatherResult)
+}
+
+// 一个目标返回或者失败
+func (c *gatherCall) complete() {
+	c.remain -= 1
+	if c.remain > 0 {
+		return
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.done(c.results)
+}
+
+// 分散聚合请求，同一个请求发给所有目标，全部返回或者超时后调用一次done，结果按targets的顺序
+// 通道满的目标不等待，直接记为ErrMailboxFull，超时没返回的目标记为ErrRequestTimeout
+// done在持有者的goroutine中调用，所有目标都发送失败时在Gather返回前调用
+func (r *Requester) Gather(targets []IRequestHandler, msgId uint32, args interface{}, timeout time.Duration, done func([]GatherResult)) {
+	results := make([]GatherResult, len(targets))
+	for i, target := range targets {
+		results[i].Target = target
+	}
+	r.gather(results, msgId, args, timeout, done)
+}
+
+// 按key分散聚合请求，key由resolve解析成key报名的节点，解析失败的key记为resolve返回的错误
+// 请求带着key，节点把它交给key的持有者处理，持有者可以用RequestKey取得请求的key
+func (r *Requester) GatherKeys(keys []interface{}, resolve GatherResolver, msgId uint32, args interface{}, timeout time.Duration, done func([]GatherResult)) {
+	results := make([]GatherResult, len(keys))
+	for i, key := range keys {
+		results[i].Key = key
+		results[i].Target, results[i].Err = resolve(key)
+	}
+	r.gather(results, msgId, args, timeout, done)
+}
+
+// 发送聚合请求，返回按请求序号匹配
+func (r *Requester) gather(results []GatherResult, msgId uint32, args interface{}, timeout time.Duration, done func([]GatherResult)) {
+	if timeout <= 0 {
+		timeout = GatherTimeout
+	}
+	call := &gatherCall{
+		results: results,
+		pending: make([]*pendingRequest, len(results)),
+		remain:  len(results),
+		done:    done,
+	}
+	for i := range results {
+		result := &results[i]
+		if result.Err != nil {
+			call.remain -= 1
+			continue
+		}
+		p := &pendingRequest{
+			reqId:    r.owner.nextRequestId(),
+			msgId:    msgId,
+			args:     args,
+			receiver: result.Target,
+			tracked:  true,
+			key:      result.Key,
+		}
+		p.onReply = func(msgId uint32, args interface{}) {
+			result.MsgId = msgId
+			result.Args = args
+			call.complete()
+		}
+		p.onError = func(err error) {
+			result.Err = err
+			call.complete()
+		}
+		r.pending[p.reqId] = p
+		if err := r.sendPending(p, true); err != nil {
+			delete(r.pending, p.reqId)
+			result.Err = err
+			call.remain -= 1
+			continue
+		}
+		call.pending[i] = p
+	}
+	// 返回在持有者的goroutine中处理，不会在发送期间到达
+	if call.remain == 0 {
+		done(results)
+		return
+	}
+	call.timer = r.owner.AfterFunc(timeout, func() {
+		call.timer = nil
+		for i, p := range call.pending {
+			if p == nil {
+				continue
+			}
+			if _, o := r.pending[p.reqId]; !o {
+				continue
+			}
+			delete(r.pending, p.reqId)
+			p.report(ErrRequestTimeout)
+			results[i].Err = ErrRequestTimeout
+		}
+		call.remain = 0
+		done(results)
+	})
+}
+
+// 正在处理的按key请求的key，不是按key的请求时返回nil，只能在处理消息的goroutine中调用
+func (h *RequestHandler) RequestKey() interface{} {
+	if h.current == nil {
+		return nil
+	}
+	return h.current.toKey
+}
+
+// 正在处理的按key请求的key，不是按key的请求时返回nil，只能在处理消息的goroutine中调用
+func (s *LocalService) RequestKey() interface{} {
+	return s.requestHandler.RequestKey()
+}
@@ -0,0 +1,187 @@
+package gproc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// 创建返回自身名字的服务，silent的服务从不返回
+func newGatherService(name string, silent bool) *LocalService {
+	service := NewDefaultLocalService()
+	service.RegisterHandle(MsgIdGetItemList, func(sender ISender, args interface{}) {
+		if !silent {
+			sender.Send(MsgIdGetItemList, name+":"+args.(string))
+		}
+	})
+	return service
+}
+
+func TestGather(t *testing.T) {
+	a := newGatherService("a", false)
+	b := newGatherService("b", false)
+	slow := newGatherService("slow", true)
+	closed := newGatherService("closed", false)
+	for _, s := range []*LocalService{a, b, slow} {
+		go s.Run()
+		defer s.Close()
+	}
+	closed.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(a, "matcher")
+	// 普通回调不会收到聚合请求的返回
+	r.RegisterCallback(MsgIdGetItemList, func(args interface{}) {
+		t.Errorf("gather reply dispatched to callback: %v", args)
+	})
+
+	var results []GatherResult
+	calls := 0
+	r.Gather([]IRequestHandler{a, slow, closed, b}, MsgIdGetItemList, "q", time.Millisecond*50, func(rs []GatherResult) {
+		calls += 1
+		results = rs
+	})
+	waitUntil(t, func() bool { return results != nil }, p)
+	if results[0].Err != nil || results[0].Args != "a:q" || results[0].Target != a {
+		t.Fatalf("unexpected result %+v", results[0])
+	}
+	if results[1].Err != ErrRequestTimeout {
+		t.Fatalf("expect timeout, got %+v", results[1])
+	}
+	if results[2].Err != ErrClosed {
+		t.Fatalf("expect ErrClosed, got %+v", results[2])
+	}
+	if results[3].Err != nil || results[3].Args != "b:q" {
+		t.Fatalf("unexpected result %+v", results[3])
+	}
+	time.Sleep(time.Millisecond * 20)
+	p.Update()
+	if calls != 1 {
+		t.Fatalf("expect done called once, got %v", calls)
+	}
+
+	// 全部返回时不等超时
+	results = nil
+	start := time.Now()
+	r.Gather([]IRequestHandler{a, b}, MsgIdGetItemList, "all", time.Second, func(rs []GatherResult) {
+		results = rs
+	})
+	waitUntil(t, func() bool { return results != nil }, p)
+	if time.Since(start) >= time.Second || results[0].Args != "a:all" || results[1].Args != "b:all" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+}
+
+// 创建持有key的服务，在node上报名并返回自己的名字和请求的key
+func newGatherKeyOwner(name string, node IRequestHandler, key interface{}) *LocalService {
+	owner := NewDefaultLocalService()
+	owner.NewRequester(node, key)
+	owner.RegisterHandle(MsgIdGetItemList, func(sender ISender, args interface{}) {
+		sender.Send(MsgIdGetItemList, fmt.Sprintf("%v:%v:%v", name, owner.RequestKey(), args))
+	})
+	return owner
+}
+
+func TestGatherKeys(t *testing.T) {
+	a := newGatherService("a", false)
+	b := newGatherService("b", false)
+	directory := NewKeyDirectory()
+	a.SetKeyDirectory(directory, "a")
+	b.SetKeyDirectory(directory, "b")
+	directory.AddNode("a", a)
+	directory.AddNode("b", b)
+	// 两个key在同一个节点a上，一个在节点b上
+	services := []*LocalService{a, b,
+		newGatherKeyOwner("o1", a, "room1"),
+		newGatherKeyOwner("o2", a, "room2"),
+		newGatherKeyOwner("o3", b, "room3"),
+	}
+	for _, s := range services {
+		go s.Run()
+		defer s.Close()
+	}
+	waitUntil(t, func() bool {
+		for _, key := range []string{"room1", "room2", "room3"} {
+			if _, _, o := directory.Lookup(key); !o {
+				return false
+			}
+		}
+		return true
+	})
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(a, "matcher")
+	var results []GatherResult
+	r.GatherKeys([]interface{}{"room2", "room4", "room1", "room3"}, KeyDirectoryResolver(directory), MsgIdGetItemList, "q", time.Second, func(rs []GatherResult) {
+		results = rs
+	})
+	waitUntil(t, func() bool { return results != nil }, p)
+	if results[0].Key != "room2" || results[0].Args != "o2:room2:q" || results[0].Target != a {
+		t.Fatalf("unexpected result %+v", results[0])
+	}
+	if results[1].Key != "room4" || results[1].Err != ErrNotFoundRequesterKey || results[1].Target != nil {
+		t.Fatalf("unexpected result %+v", results[1])
+	}
+	if results[2].Key != "room1" || results[2].Args != "o1:room1:q" || results[2].Target != a {
+		t.Fatalf("unexpected result %+v", results[2])
+	}
+	if results[3].Key != "room3" || results[3].Args != "o3:room3:q" || results[3].Target != b {
+		t.Fatalf("unexpected result %+v", results[3])
+	}
+
+	// 请求到key不在的节点时由节点转交给key所在的节点
+	results = nil
+	wrong := func(key interface{}) (IRequestHandler, error) {
+		return b, nil
+	}
+	r.GatherKeys([]interface{}{"room1"}, wrong, MsgIdGetItemList, "relay", time.Second, func(rs []GatherResult) {
+		results = rs
+	})
+	waitUntil(t, func() bool { return results != nil }, p)
+	if results[0].Args != "o1:room1:relay" {
+		t.Fatalf("unexpected relayed result %+v", results[0])
+	}
+
+	// 全部解析失败时立即完成
+	results = nil
+	r.GatherKeys([]interface{}{"room4"}, KeyDirectoryResolver(directory), MsgIdGetItemList, "q", time.Second, func(rs []GatherResult) {
+		results = rs
+	})
+	if len(results) != 1 || results[0].Err != ErrNotFoundRequesterKey {
+		t.Fatalf("expect immediate completion, got %+v", results)
+	}
+}
+
+func TestGatherKeysResponseHandlerOwner(t *testing.T) {
+	node := newGatherService("node", false)
+	go node.Run()
+	defer node.Close()
+	breaker := NewDefaultCircuitBreaker(node)
+
+	// key的持有者只是ResponseHandler，不能处理请求，报名排在请求之前
+	owner := NewDefaultResponseHandler()
+	defer owner.Close()
+	owner.CreateRequester(node, "room1")
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(node, "matcher")
+
+	var results []GatherResult
+	resolve := func(key interface{}) (IRequestHandler, error) {
+		return breaker, nil
+	}
+	start := time.Now()
+	r.GatherKeys([]interface{}{"room1"}, resolve, MsgIdGetItemList, "q", time.Second, func(rs []GatherResult) {
+		results = rs
+	})
+	waitUntil(t, func() bool { return results != nil }, p)
+	if results[0].Err != ErrKeyOwnerCantHandleRequest || time.Since(start) >= time.Second {
+		t.Fatalf("expect ErrKeyOwnerCantHandleRequest before timeout, got %+v", results[0])
+	}
+	// 按key的请求经过熔断器计数，错误返回报告为失败
+	if stats := breaker.Stats(); stats.Requests != 1 || stats.Failures != 1 {
+		t.Fatalf("expect 1 request and 1 failure, got %+v", stats)
+	}
+}
@@ -0,0 +1,38 @@
+package gproctest
+
+import (
+	"sync"
+	"time"
+)
+
+// 虚拟时钟的默认起始时间
+var ClockStart = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// 手动推进的虚拟时钟
+type ManualClock struct {
+	locker sync.Mutex
+	now    time.Time
+}
+
+// 创建虚拟时钟，start为零值时从ClockStart开始
+func NewManualClock(start time.Time) *ManualClock {
+	if start.IsZero() {
+		start = ClockStart
+	}
+	return &ManualClock{now: start}
+}
+
+// 当前时间
+func (c *ManualClock) Now() time.Time {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.now
+}
+
+// 推进时间
+func (c *ManualClock) Advance(d time.Duration) time.Time {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
@@ -0,0 +1,110 @@
+package gproctest
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/huoshan017/gproc"
+)
+
+const (
+	ExecutorMaxSteps = 1000000 // RunUntilIdle最多处理的消息数，避免消息循环时测试卡死
+)
+
+// 可以逐步执行的对象
+type steppable interface {
+	Pending() int
+	Step() bool
+	RunTimers(now time.Time)
+}
+
+// 确定性执行器，在测试的goroutine中逐条处理所有服务的消息，服务不调用Run
+// 每一步从有消息的服务中用种子随机选择一个，同一个种子得到同样的消息交错顺序
+// 定时器和定时处理由虚拟时钟驱动，只在Advance时执行
+// 加入的对象改为逐步执行，邮箱满时阻塞的发送会永远等待，改为panic(gproc.ErrSteppingMailboxFull)让测试失败
+type Executor struct {
+	clock   *ManualClock
+	rand    *rand.Rand
+	targets []steppable
+}
+
+// 创建执行器
+func NewExecutor(seed int64) *Executor {
+	return &Executor{
+		clock: NewManualClock(time.Time{}),
+		rand:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+// 虚拟时钟
+func (e *Executor) Clock() *ManualClock {
+	return e.clock
+}
+
+// 加入服务，服务改用虚拟时钟
+func (e *Executor) AddService(services ...*gproc.LocalService) {
+	for _, s := range services {
+		s.SetClock(e.clock)
+		s.SetStepping(true)
+		e.targets = append(e.targets, s)
+	}
+}
+
+// 加入请求处理器，定时处理由虚拟时钟驱动，不再需要调用Run
+func (e *Executor) AddRequestHandler(handlers ...*gproc.RequestHandler) {
+	for _, h := range handlers {
+		h.SetStepping(true)
+		e.targets = append(e.targets, h)
+	}
+}
+
+// 加入返回处理器，返回处理器改用虚拟时钟，不再需要调用Update
+func (e *Executor) AddResponseHandler(handlers ...*gproc.ResponseHandler) {
+	for _, h := range handlers {
+		h.SetClock(e.clock)
+		h.SetStepping(true)
+		e.targets = append(e.targets, h)
+	}
+}
+
+// 处理一条消息，所有服务都没有消息时返回false
+func (e *Executor) Step() bool {
+	var runnable []steppable
+	for _, t := range e.targets {
+		if t.Pending() > 0 {
+			runnable = append(runnable, t)
+		}
+	}
+	for len(runnable) > 0 {
+		i := e.rand.Intn(len(runnable))
+		if runnable[i].Step() {
+			return true
+		}
+		// 已关闭的服务不再处理
+		runnable = append(runnable[:i], runnable[i+1:]...)
+	}
+	return false
+}
+
+// 处理消息直到所有服务都没有消息，返回处理的消息数
+func (e *Executor) RunUntilIdle() int {
+	n := 0
+	for n < ExecutorMaxSteps && e.Step() {
+		n += 1
+	}
+	return n
+}
+
+// 推进虚拟时钟，执行到期的定时器和定时处理，不处理消息
+func (e *Executor) Advance(d time.Duration) {
+	now := e.clock.Advance(d)
+	for _, t := range e.targets {
+		t.RunTimers(now)
+	}
+}
+
+// 推进虚拟时钟后处理消息直到空闲
+func (e *Executor) AdvanceAndRun(d time.Duration) int {
+	e.Advance(d)
+	return e.RunUntilIdle()
+}
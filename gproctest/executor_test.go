@@ -0,0 +1,141 @@
+package gproctest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/huoshan017/gproc"
+)
+
+const (
+	msgIdPing = 1
+	msgIdPong = 2
+)
+
+// 两个服务都回复ping，记录处理顺序
+func runPingPong(seed int64) []string {
+	var order []string
+	e := NewExecutor(seed)
+	a := gproc.NewDefaultLocalService()
+	b := gproc.NewDefaultLocalService()
+	for name, s := range map[string]*gproc.LocalService{"a": a, "b": b} {
+		name := name
+		s.RegisterHandle(msgIdPing, func(sender gproc.ISender, args interface{}) {
+			order = append(order, name+":"+args.(string))
+			sender.Send(msgIdPong, name)
+		})
+	}
+	client := gproc.NewDefaultResponseHandler()
+	e.AddService(a, b)
+	e.AddResponseHandler(client)
+
+	ra := client.CreateRequester(a, "client")
+	rb := client.CreateRequester(b, "client")
+	pongs := 0
+	// 两个请求者都注册了pong，返回按请求者创建的顺序交给第一个能处理的
+	ra.RegisterCallback(msgIdPong, func(args interface{}) {
+		pongs += 1
+		order = append(order, "ra<-"+args.(string))
+	})
+	rb.RegisterCallback(msgIdPong, func(args interface{}) {
+		pongs += 1
+		order = append(order, "rb<-"+args.(string))
+	})
+	for _, args := range []string{"1", "2", "3"} {
+		ra.Request(msgIdPing, args)
+		rb.Request(msgIdPing, args)
+	}
+	e.RunUntilIdle()
+	if pongs != 6 {
+		return nil
+	}
+	return order
+}
+
+func TestExecutorSeededInterleaving(t *testing.T) {
+	first := runPingPong(42)
+	if len(first) != 12 {
+		t.Fatalf("expect 6 pings and 6 pongs handled, got %v", first)
+	}
+	for i := 0; i < 10; i++ {
+		if order := runPingPong(42); !reflect.DeepEqual(order, first) {
+			t.Fatalf("same seed expect same order %v, got %v", first, order)
+		}
+	}
+}
+
+func TestExecutorVirtualClock(t *testing.T) {
+	e := NewExecutor(1)
+	s := gproc.NewDefaultLocalService()
+	ticks := 0
+	s.SetTickHandle(func(tick time.Duration) {
+		if tick != time.Millisecond*100 {
+			t.Errorf("unexpected tick %v", tick)
+		}
+		ticks += 1
+	}, time.Millisecond*100)
+	e.AddService(s)
+
+	fired := false
+	s.AfterFunc(time.Second*5, func() {
+		fired = true
+	})
+	e.Advance(time.Second)
+	if ticks != 10 || fired {
+		t.Fatalf("expect 10 ticks and timer not fired, got %v %v", ticks, fired)
+	}
+	e.Advance(time.Second * 4)
+	if ticks != 50 || !fired {
+		t.Fatalf("expect 50 ticks and timer fired, got %v %v", ticks, fired)
+	}
+	if n := e.RunUntilIdle(); n != 0 {
+		t.Fatalf("expect idle, processed %v", n)
+	}
+}
+
+// 请求超时由虚拟时钟决定，不需要真的等待
+func TestExecutorRequestTimeout(t *testing.T) {
+	e := NewExecutor(1)
+	s := gproc.NewDefaultLocalService()
+	s.RegisterHandle(msgIdPing, func(sender gproc.ISender, args interface{}) {})
+	client := gproc.NewDefaultResponseHandler()
+	e.AddService(s)
+	e.AddResponseHandler(client)
+
+	var failErr error
+	r := client.CreateRequester(s, "client", gproc.RequestTimeout(1000), gproc.RequestFailHandle(func(msgId uint32, args interface{}, err error) {
+		failErr = err
+	}))
+	r.Request(msgIdPing, nil)
+	e.RunUntilIdle()
+	e.AdvanceAndRun(time.Millisecond * 999)
+	if failErr != nil {
+		t.Fatalf("timeout before deadline: %v", failErr)
+	}
+	e.AdvanceAndRun(time.Millisecond)
+	if failErr != gproc.ErrRequestTimeout {
+		t.Fatalf("expect ErrRequestTimeout, got %v", failErr)
+	}
+}
+
+// 处理函数发送超过邮箱容量的消息时panic，不会卡住执行器
+func TestExecutorMailboxFull(t *testing.T) {
+	e := NewExecutor(1)
+	s := gproc.NewDefaultLocalService()
+	client := gproc.NewLocalService(2)
+	s.RegisterHandle(msgIdPing, func(sender gproc.ISender, args interface{}) {
+		for i := 0; i < 3; i++ {
+			sender.Send(msgIdPong, i)
+		}
+	})
+	e.AddService(s, client)
+	r := client.NewRequester(s, "client")
+	r.Request(msgIdPing, nil)
+	defer func() {
+		if err := recover(); err != gproc.ErrSteppingMailboxFull {
+			t.Fatalf("expect ErrSteppingMailboxFull panic, got %v", err)
+		}
+	}()
+	e.RunUntilIdle()
+}
@@ -0,0 +1,130 @@
+package gproctest
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huoshan017/gproc"
+)
+
+const (
+	ProbeTimeout = time.Duration(3 * time.Second) // ExpectMsg的默认等待时间
+)
+
+// 测试探针，实现ISender和IResponseHandler，记录所有发给它的消息
+// 可以直接作为处理函数的sender调用处理函数，也可以作为requester的持有者向服务发请求
+type Probe struct {
+	gproc.IResponseHandler
+	t        testing.TB
+	locker   sync.Mutex
+	cond     *sync.Cond
+	queue    []gproc.SentMsg // 还没被Expect取走的消息
+	received []gproc.SentMsg // 收到的所有消息
+}
+
+// 创建探针
+func NewProbe(t testing.TB) *Probe {
+	p := &Probe{t: t}
+	p.cond = sync.NewCond(&p.locker)
+	p.IResponseHandler = gproc.NewSendObserver(p.record)
+	return p
+}
+
+// 记录消息
+func (p *Probe) record(m gproc.SentMsg) {
+	p.locker.Lock()
+	p.queue = append(p.queue, m)
+	p.received = append(p.received, m)
+	p.locker.Unlock()
+	p.cond.Broadcast()
+}
+
+// 收到的所有消息
+func (p *Probe) Received() []gproc.SentMsg {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return append([]gproc.SentMsg(nil), p.received...)
+}
+
+// 等待下一条消息，超时返回false
+func (p *Probe) Receive(timeout time.Duration) (gproc.SentMsg, bool) {
+	if timeout <= 0 {
+		timeout = ProbeTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	// cond不能带超时等待，到期时加锁后唤醒，避免在检查和Wait之间错过
+	timer := time.AfterFunc(timeout, func() {
+		p.locker.Lock()
+		p.locker.Unlock()
+		p.cond.Broadcast()
+	})
+	defer timer.Stop()
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	for len(p.queue) == 0 {
+		if !time.Now().Before(deadline) {
+			return gproc.SentMsg{}, false
+		}
+		p.cond.Wait()
+	}
+	m := p.queue[0]
+	p.queue = p.queue[1:]
+	return m, true
+}
+
+// 期望下一条消息是msgId，返回消息的数据
+func (p *Probe) ExpectMsg(msgId uint32, timeout time.Duration) interface{} {
+	p.t.Helper()
+	m, o := p.Receive(timeout)
+	if !o {
+		p.t.Fatalf("gproctest: timeout waiting for msg %v", msgId)
+		return nil
+	}
+	if m.MsgId != msgId {
+		p.t.Fatalf("gproctest: expect msg %v, got %v(%v) %v", msgId, m.Type, m.MsgId, m.Args)
+	}
+	return m.Args
+}
+
+// 期望下一条消息是msgId，数据与expected深度相等
+func (p *Probe) ExpectMsgArgs(msgId uint32, expected interface{}, timeout time.Duration) {
+	p.t.Helper()
+	args := p.ExpectMsg(msgId, timeout)
+	if !reflect.DeepEqual(args, expected) {
+		p.t.Fatalf("gproctest: msg %v expect args %#v, got %#v", msgId, expected, args)
+	}
+}
+
+// 期望下一条消息是msgId，数据的类型与prototype相同
+func (p *Probe) ExpectMsgType(msgId uint32, prototype interface{}, timeout time.Duration) interface{} {
+	p.t.Helper()
+	args := p.ExpectMsg(msgId, timeout)
+	if reflect.TypeOf(args) != reflect.TypeOf(prototype) {
+		p.t.Fatalf("gproctest: msg %v expect args type %T, got %T", msgId, prototype, args)
+	}
+	return args
+}
+
+// 期望下一条消息是转发的msgId，返回发起者的key和数据
+func (p *Probe) ExpectForward(msgId uint32, timeout time.Duration) (interface{}, interface{}) {
+	p.t.Helper()
+	m, o := p.Receive(timeout)
+	if !o {
+		p.t.Fatalf("gproctest: timeout waiting for forward %v", msgId)
+		return nil, nil
+	}
+	if m.Type != "forwarded" || m.MsgId != msgId {
+		p.t.Fatalf("gproctest: expect forward %v, got %v(%v) %v", msgId, m.Type, m.MsgId, m.Args)
+	}
+	return m.FromKey, m.Args
+}
+
+// 期望d时间内没有消息
+func (p *Probe) ExpectNoMsg(d time.Duration) {
+	p.t.Helper()
+	if m, o := p.Receive(d); o {
+		p.t.Fatalf("gproctest: expect no msg, got %v(%v) %v", m.Type, m.MsgId, m.Args)
+	}
+}
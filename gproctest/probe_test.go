@@ -0,0 +1,55 @@
+package gproctest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/huoshan017/gproc"
+)
+
+type itemList struct {
+	Items []int32
+}
+
+// 直接用探针作为sender调用处理函数
+func TestProbeAsSender(t *testing.T) {
+	handle := func(sender gproc.ISender, args interface{}) {
+		sender.Send(msgIdPong, &itemList{Items: []int32{1, 2}})
+		sender.Send(msgIdPing, "done")
+	}
+	probe := NewProbe(t)
+	handle(probe, nil)
+	list := probe.ExpectMsgType(msgIdPong, &itemList{}, time.Second).(*itemList)
+	if len(list.Items) != 2 {
+		t.Fatalf("unexpected items %v", list.Items)
+	}
+	probe.ExpectMsgArgs(msgIdPing, "done", time.Second)
+	probe.ExpectNoMsg(time.Millisecond * 10)
+	if n := len(probe.Received()); n != 2 {
+		t.Fatalf("expect 2 received, got %v", n)
+	}
+}
+
+// 探针作为requester的持有者，收到运行中的服务的返回和转发
+func TestProbeAsRequesterOwner(t *testing.T) {
+	service := gproc.NewDefaultLocalService()
+	service.RegisterHandle(msgIdPing, func(sender gproc.ISender, args interface{}) {
+		sender.Send(msgIdPong, args)
+	})
+	go service.Run()
+	defer service.Close()
+
+	alice := NewProbe(t)
+	bob := NewProbe(t)
+	ra := alice.CreateRequester(service, "alice")
+	bob.CreateRequester(service, "bob")
+	ra.Request(msgIdPing, "hi")
+	alice.ExpectMsgArgs(msgIdPong, "hi", time.Second)
+
+	ra.RequestForward("bob", msgIdPing, "hello bob")
+	fromKey, args := bob.ExpectForward(msgIdPing, time.Second)
+	if fromKey != "alice" || args != "hello bob" {
+		t.Fatalf("unexpected forward %v %v", fromKey, args)
+	}
+	alice.ExpectNoMsg(time.Millisecond * 10)
+}
@@ -1,6 +1,8 @@
 package gproc
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -9,11 +11,30 @@ const (
 	ServiceTickDuration = time.Duration(10 * time.Millisecond) // 定时器间隔
 )
 
+// 处理器状态
+const (
+	handlerCreated  int32 = 0 // 已创建，可以接收消息，处理循环还没开始
+	handlerRunning  int32 = 1 // 处理循环运行中
+	handlerStopping int32 = 2 // 已关闭，等待处理循环退出
+	handlerStopped  int32 = 3 // 处理循环已退出
+)
+
 // 消息处理器
 type handler struct {
-	ch      chan *msg
-	closed  bool
-	chClose chan struct{}
+	ch            chan *msg
+	state         int32         // 状态，原子操作
+	chClose       chan struct{} // 关闭信号
+	chDone        chan struct{} // 处理循环退出后关闭
+	closeOnce     sync.Once
+	doneOnce      sync.Once
+	signal        atomic.Value  // 消息进入通道后的通知func()，调度器模式下用来把服务放入运行队列
+	faults        atomic.Value  // 故障注入器*FaultInjector
+	span          SpanContext   // 正在处理的消息的span，只在处理循环中使用
+	headers       Headers       // 正在处理的消息的消息头，只在处理循环中使用
+	name          string        // 服务或返回处理器的名字，用于轨迹、跟踪和日志
+	logger        Logger        // 日志，为nil时使用全局日志
+	slowThreshold time.Duration // 慢处理阈值，0使用默认值，<0不检查
+	stepping      bool          // 逐步执行，通道满时阻塞的发送改为panic
 }
 
 // 新的处理器
@@ -35,32 +56,159 @@ func (h *handler) Init(chanLen int32) {
 	}
 	h.ch = make(chan *msg, chanLen)
 	h.chClose = make(chan struct{})
+	h.chDone = make(chan struct{})
 }
 
-// 关闭
+// 开始处理循环
+func (h *handler) start() error {
+	if atomic.CompareAndSwapInt32(&h.state, handlerCreated, handlerRunning) {
+		return nil
+	}
+	if atomic.LoadInt32(&h.state) == handlerRunning {
+		return ErrAlreadyRunning
+	}
+	return ErrClosed
+}
+
+// 处理循环退出，通道中没有处理的消息作为死信
+// 没有调用Close退出的(比如panic)也关闭chClose，让等待通道的发送返回
+func (h *handler) stop() {
+	atomic.StoreInt32(&h.state, handlerStopped)
+	h.closeOnce.Do(func() {
+		close(h.chClose)
+	})
+	h.drain()
+	h.doneOnce.Do(func() {
+		close(h.chDone)
+		h.log(LogLevelInfo, LogEventClosed)
+	})
+}
+
+// 取出通道中剩下的消息，作为死信发布
+func (h *handler) drain() {
+	for {
+		select {
+		case m := <-h.ch:
+			publishDeadLetter(DeadLetterClosed, m, ErrClosed)
+			putMsg(m)
+		default:
+			return
+		}
+	}
+}
+
+// 关闭，处理循环没有运行时直接进入停止状态
 func (h *handler) Close() {
-	if h.closed {
-		return
+	for {
+		state := atomic.LoadInt32(&h.state)
+		if state >= handlerStopping {
+			return
+		}
+		next := handlerStopping
+		if state == handlerCreated {
+			next = handlerStopped
+		}
+		if atomic.CompareAndSwapInt32(&h.state, state, next) {
+			h.closeOnce.Do(func() {
+				close(h.chClose)
+			})
+			if next == handlerStopped {
+				h.stop()
+			}
+			return
+		}
 	}
-	close(h.chClose)
-	h.closed = true
 }
 
 // 是否关闭
 func (h *handler) IsClosed() bool {
-	return h.closed
+	return atomic.LoadInt32(&h.state) >= handlerStopping
+}
+
+// 是否运行中
+func (h *handler) IsRunning() bool {
+	return atomic.LoadInt32(&h.state) == handlerRunning
 }
 
-// 内部发送函数
+// 处理循环退出后关闭的通道
+func (h *handler) Done() <-chan struct{} {
+	return h.chDone
+}
+
+// 内部发送函数，关闭后返回ErrClosed，通道满时等待，等待中关闭也返回ErrClosed
+// 消息设置了noWait时通道满直接返回ErrMailboxFull
 func (h *handler) Send(m *msg) error {
-	// 已关闭，防止重复close造成panic
-	if h.closed {
+	if f := h.faultInjector(); f != nil && !h.IsClosed() {
+		if handled, err := f.inject(h, m); handled {
+			return err
+		}
+	}
+	return h.deliver(m)
+}
+
+// 投递到通道
+func (h *handler) deliver(m *msg) error {
+	if h.IsClosed() {
+		publishDeadLetter(DeadLetterClosed, m, ErrClosed)
 		return ErrClosed
 	}
-	h.ch <- m
+	if m.noWait {
+		select {
+		case h.ch <- m:
+		default:
+			publishDeadLetter(DeadLetterMailboxFull, m, ErrMailboxFull)
+			return ErrMailboxFull
+		}
+	} else if h.stepping {
+		// 逐步执行时处理和发送在同一个goroutine，阻塞的发送永远等不到处理
+		select {
+		case h.ch <- m:
+		default:
+			panic(ErrSteppingMailboxFull)
+		}
+	} else {
+		select {
+		case h.ch <- m:
+		case <-h.chClose:
+			publishDeadLetter(DeadLetterClosed, m, ErrClosed)
+			return ErrClosed
+		}
+	}
+	h.afterEnqueue()
 	return nil
 }
 
+// 不等待投递到通道，失败时不发布死信，由调用者稍后重试或者丢弃
+func (h *handler) offer(m *msg) error {
+	if h.IsClosed() {
+		return ErrClosed
+	}
+	select {
+	case h.ch <- m:
+	default:
+		return ErrMailboxFull
+	}
+	h.afterEnqueue()
+	return nil
+}
+
+// 消息进入通道后，处理循环已经停止的话，停止时的清理可能已经做完，再清理一次，否则通知调度器
+// 进入通道的消息算投递成功，和停止前没处理的消息一样作为死信
+func (h *handler) afterEnqueue() {
+	if atomic.LoadInt32(&h.state) == handlerStopped {
+		h.drain()
+		return
+	}
+	h.notify()
+}
+
+// 消息进入通道后通知调度器
+func (h *handler) notify() {
+	if signal, _ := h.signal.Load().(func()); signal != nil {
+		signal()
+	}
+}
+
 // 请求消息处理器
 type RequestHandler struct {
 	handler               *handler
@@ -69,6 +217,14 @@ type RequestHandler struct {
 	tickHandle            func(tick time.Duration)
 	forwardNoTargetHandle map[uint32]func(sender ISender, toKey interface{}, args interface{})
 	tick                  time.Duration
+	directory             IKeyDirectory   // 分布式key目录，本地找不到key时到目录中查找
+	node                  string          // 在key目录中的节点名
+	behaviors             []Behavior      // Become压入的处理函数表，栈顶的替换handleMap
+	current               *msg            // 正在处理的请求消息
+	stash                 []*msg          // 暂存的请求消息
+	unstashed             []*msg          // 等待重放的暂存消息
+	mailbox               *DurableMailbox // 持久邮箱
+	lastTick              time.Time       // 逐步执行时上次定时处理的时间
 }
 
 // 创建RequestHandler
@@ -101,6 +257,16 @@ func (h *RequestHandler) Close() {
 	h.handler.Close()
 }
 
+// 是否运行中
+func (h *RequestHandler) IsRunning() bool {
+	return h.handler.IsRunning()
+}
+
+// 处理循环退出后关闭的通道
+func (h *RequestHandler) Done() <-chan struct{} {
+	return h.handler.Done()
+}
+
 // 设置定时器处理
 func (h *RequestHandler) SetTickHandle(handle func(tick time.Duration), tick time.Duration) {
 	h.tickHandle = handle
@@ -110,6 +276,12 @@ func (h *RequestHandler) SetTickHandle(handle func(tick time.Duration), tick tim
 	h.tick = tick
 }
 
+// 设置key目录，本地找不到转发和通知的目标时，通过目录转交给目标所在的节点
+func (h *RequestHandler) SetKeyDirectory(directory IKeyDirectory, node string) {
+	h.directory = directory
+	h.node = node
+}
+
 // 注册
 func (h *RequestHandler) RegisterHandle(msgId uint32, handle func(ISender, interface{})) {
 	h.handleMap[msgId] = handle
@@ -117,28 +289,54 @@ func (h *RequestHandler) RegisterHandle(msgId uint32, handle func(ISender, inter
 
 // 注册无目标转发时的处理器
 func (h *RequestHandler) RegisterForward4NoTarget(msgId uint32, handle func(ISender, interface{}, interface{})) {
-
+	h.forwardNoTargetHandle[msgId] = handle
 }
 
 // 接收消息，实际等于Channel发送消息
 func (h *RequestHandler) recv(m *msg) error {
+	// 设置了持久邮箱的请求先写入日志，进不了通道的不再重放
+	if h.mailbox != nil && m.typ == msgNormal {
+		if err := h.mailbox.append(m); err != nil {
+			return err
+		}
+		seq := m.walSeq
+		err := h.handler.Send(m)
+		if err != nil {
+			h.mailbox.ack(seq)
+		}
+		return err
+	}
 	return h.handler.Send(m)
 }
 
 // 通知
-func (h *RequestHandler) Notify(toKey interface{}, msgId uint32, args interface{}) error {
+func (h *RequestHandler) Notify(toKey interface{}, msgId uint32, args interface{}, options ...SendOption) error {
+	span := startSendSpan(h.handler.span, SpanKindNotify, toKey, msgId)
+	headers := sendHeaders(nil, options)
 	s, o := h.signUpMap[toKey]
 	if !o {
-		return ErrNotFoundRequesterKey
+		return h.relayNotify(toKey, msgId, args, span, headers)
 	}
-	return s.Send(msgId, args)
+	return notifyTo(s, msgId, args, span, headers)
+}
+
+// 通知报名的发送者
+func notifyTo(s ISender, msgId uint32, args interface{}, span SpanContext, headers Headers) error {
+	m := getMsg()
+	m.typ = msgReply
+	m.id = msgId
+	m.args = args
+	m.span = span
+	m.headers = headers
+	return s.send(m)
 }
 
 // 处理接收的消息
 func (h *RequestHandler) Run() error {
-	if h.handler.closed {
-		return ErrClosed
+	if err := h.handler.start(); err != nil {
+		return err
 	}
+	defer h.handler.stop()
 
 	var lastTime time.Time
 	var ticker *time.Ticker
@@ -155,9 +353,8 @@ func (h *RequestHandler) Run() error {
 				if !o {
 					return ErrClosed
 				}
-				h.handleMsg(m)
+				h.processMsg(m)
 			case <-h.handler.chClose:
-				h.handler.closed = true
 				loop = false
 			}
 		}
@@ -168,14 +365,13 @@ func (h *RequestHandler) Run() error {
 				if !o {
 					return ErrClosed
 				}
-				h.handleMsg(m)
+				h.processMsg(m)
 			case <-ticker.C:
 				now := time.Now()
 				tick := now.Sub(lastTime)
 				h.tickHandle(tick)
 				lastTime = now
 			case <-h.handler.chClose:
-				h.handler.closed = true
 				loop = false
 			}
 		}
@@ -183,29 +379,84 @@ func (h *RequestHandler) Run() error {
 	return nil
 }
 
-func (h *RequestHandler) handleMsg(m *msg) bool {
-	result := true
+// 处理消息，处理失败的作为死信
+func (h *RequestHandler) processMsg(m *msg) {
+	if info := h.handler.beginMsgLog(m); info != nil {
+		defer h.handler.endMsgLog(info)
+	}
+	span := beginHandleSpan(h.handler, m)
+	if err := h.handleMsg(m); err != nil {
+		h.handler.logMsgError(m, err)
+		publishDeadLetter(requestDeadLetterReason(m), m, err)
+	}
+	endHandleSpan(h.handler, span)
+	putMsg(m)
+	h.replayUnstashed()
+}
+
+// 处理消息，不回收消息，由调用者回收
+func (h *RequestHandler) handleMsg(m *msg) error {
+	headers := h.handler.headers
+	h.handler.headers = m.headers
+	defer func() {
+		h.handler.headers = headers
+	}()
+	var err error
 	switch m.typ {
 	case msgNormal:
-		result = h.handleReq(m.sender, m.id, m.args)
+		// 流式返回的额度要发回处理请求的处理器，返回带上请求的消息头
+		if s, o := m.sender.(*requestSender); o {
+			s.handler = h
+			s.headers = m.headers
+		}
+		h.current = m
+		if !h.handleReq(m.sender, m.id, m.args) {
+			err = ErrNotFoundRequestHandle
+		}
+		h.current = nil
+		if m.walSeq != 0 && h.mailbox != nil {
+			h.mailbox.ack(m.walSeq)
+		}
 	case msgSignup:
+		h.handler.logMsg(LogLevelDebug, LogEventSignup, m)
 		h.signUpMap[m.fromKey] = m.sender
+		if h.directory != nil {
+			h.directory.Register(m.fromKey, h.node)
+		}
 	case msgForward:
-		err := h.handleForward(m.fromKey, m.toKey, m.id, m.args)
-		// todo 错误处理先放着
-		if err != nil {
-			result = false
+		err = h.handleForward(m.fromKey, m.toKey, m.id, m.args)
+	case msgSignOff:
+		h.handler.logMsg(LogLevelDebug, LogEventSignoff, m)
+		delete(h.signUpMap, m.fromKey)
+		if h.directory != nil {
+			h.directory.Unregister(m.fromKey, h.node)
+		}
+	case msgExec:
+		m.args.(func())()
+	case msgRemoteForward:
+		err = h.handleRemoteForward(m.sender, m.fromKey, m.toKey, m.id, m.args)
+	case msgKeyRequest:
+		err = h.handleKeyRequest(m)
+	case msgRemoteNotify:
+		s, o := h.signUpMap[m.toKey]
+		if !o {
+			err = ErrNotFoundRequesterKey
+		} else {
+			err = notifyTo(s, m.id, m.args, h.handler.span, m.headers)
 		}
 	default:
-		result = false
+		err = ErrUnknownMsgType
 	}
-	putMsg(m)
-	return result
+	return err
 }
 
 // 处理单个IRequester请求后的回调
 func (h *RequestHandler) handleReq(sender ISender, msgId uint32, args interface{}) bool {
-	handle, o := h.handleMap[msgId]
+	handleMap := h.handleMap
+	if n := len(h.behaviors); n > 0 {
+		handleMap = h.behaviors[n-1]
+	}
+	handle, o := handleMap[msgId]
 	if !o {
 		return false
 	}
@@ -221,22 +472,142 @@ func (h *RequestHandler) handleForward(fromKey, toKey interface{}, msgId uint32,
 		return ErrNotFoundRequesterKey
 	}
 	r, o := h.signUpMap[toKey]
-	// 找不到toKey对应的目标，转到无目标的转发处理器
 	if !o {
-		handle, o := h.forwardNoTargetHandle[msgId]
-		if !o {
-			return ErrNotFoundNoTargetForwardHandle
+		// 本地找不到，再到key目录中找目标所在的节点
+		relayed, err := h.relayForward(s, fromKey, toKey, msgId, args)
+		if relayed {
+			return err
 		}
-		handle(s, toKey, args)
-		return nil
+		return h.handleForwardNoTarget(s, toKey, msgId, args)
+	}
+	return h.forwardTo(r, s, fromKey, msgId, args)
+}
+
+// 把转发交给目标，带上当前的span
+func (h *RequestHandler) forwardTo(target ISender, fromSender ISender, fromKey interface{}, msgId uint32, args interface{}) error {
+	m := getMsg()
+	m.typ = msgForwarded
+	m.id = msgId
+	m.sender = fromSender
+	m.fromKey = fromKey
+	m.args = args
+	m.span = h.handler.span
+	m.headers = h.handler.headers
+	return target.send(m)
+}
+
+// 处理其他节点转交过来的转发，找不到目标时不再转交，避免节点间循环
+func (h *RequestHandler) handleRemoteForward(fromSender ISender, fromKey, toKey interface{}, msgId uint32, args interface{}) error {
+	r, o := h.signUpMap[toKey]
+	if !o {
+		return h.handleForwardNoTarget(fromSender, toKey, msgId, args)
+	}
+	return h.forwardTo(r, fromSender, fromKey, msgId, args)
+}
+
+// 把按key的请求交给key的持有者，持有者作为普通请求处理，本地找不到时交给key所在的节点
+// 持有者是LocalService时由它注册的处理函数处理，返回直接发给发起者，交不出去时把错误返回给发起者
+func (h *RequestHandler) handleKeyRequest(m *msg) error {
+	err := h.relayKeyRequest(m)
+	if err != nil {
+		if s, o := m.sender.(*requestSender); o {
+			s.fail(err)
+		}
+	}
+	return err
+}
+
+// 把按key的请求交给key的持有者或者key所在的节点，持有者不是LocalService时返回ErrKeyOwnerCantHandleRequest
+func (h *RequestHandler) relayKeyRequest(m *msg) error {
+	if s, o := h.signUpMap[m.toKey]; o {
+		owner, o := s.(*ResponseHandler)
+		if !o || owner.requests == nil {
+			return ErrKeyOwnerCantHandleRequest
+		}
+		c := getMsg()
+		*c = *m
+		c.typ = msgNormal
+		return owner.requests.recv(c)
+	}
+	target, o := h.lookupRemote(m.toKey)
+	if !o {
+		return ErrNotFoundRequesterKey
+	}
+	c := getMsg()
+	*c = *m
+	c.noWait = true
+	return target.recv(c)
+}
+
+// 找不到toKey对应的目标，转到无目标的转发处理器
+func (h *RequestHandler) handleForwardNoTarget(sender ISender, toKey interface{}, msgId uint32, args interface{}) error {
+	handle, o := h.forwardNoTargetHandle[msgId]
+	if !o {
+		return ErrNotFoundNoTargetForwardHandle
+	}
+	handle(sender, toKey, args)
+	return nil
+}
+
+// 把转发交给toKey所在的节点，fromSender和fromKey一起带过去，用于目标回复
+// 带的是发起者的ISender对象，只支持同一进程内的节点
+// 在处理循环中调用，目标节点通道满时不等待，返回ErrMailboxFull
+func (h *RequestHandler) relayForward(fromSender ISender, fromKey, toKey interface{}, msgId uint32, args interface{}) (bool, error) {
+	target, o := h.lookupRemote(toKey)
+	if !o {
+		return false, nil
+	}
+	m := getMsg()
+	m.typ = msgRemoteForward
+	m.sender = fromSender
+	m.fromKey = fromKey
+	m.toKey = toKey
+	m.id = msgId
+	m.args = args
+	m.span = h.handler.span
+	m.headers = h.handler.headers
+	m.noWait = true
+	return true, target.recv(m)
+}
+
+// 把通知交给toKey所在的节点，目标节点通道满时不等待
+func (h *RequestHandler) relayNotify(toKey interface{}, msgId uint32, args interface{}, span SpanContext, headers Headers) error {
+	target, o := h.lookupRemote(toKey)
+	if !o {
+		return ErrNotFoundRequesterKey
+	}
+	m := getMsg()
+	m.typ = msgRemoteNotify
+	m.toKey = toKey
+	m.id = msgId
+	m.args = args
+	m.span = span
+	m.headers = headers
+	m.noWait = true
+	return target.recv(m)
+}
+
+// 在key目录中查找其他节点上的key
+func (h *RequestHandler) lookupRemote(key interface{}) (IRequestHandler, bool) {
+	if h.directory == nil {
+		return nil, false
+	}
+	node, target, o := h.directory.Lookup(key)
+	if !o || node == h.node {
+		return nil, false
 	}
-	return r.forward(s, fromKey, msgId, args)
+	return target, true
 }
 
 // 返回消息处理器
 type ResponseHandler struct {
-	handler      *handler
-	requesterMap map[IRequester]struct{}
+	handler    *handler
+	requesters []IRequester    // 按创建顺序保存，处理返回时按顺序查找，逐步执行的结果可以重现
+	timers     timerQueue      // 定时器，在Update或者服务循环中执行
+	requestId  uint64          // 请求序号
+	clock      Clock           // 时钟，为nil时使用系统时间
+	recorder   IRecorder       // 记录取出的返回
+	requests   *RequestHandler // 共用通道的请求处理器，LocalService的持有者才有，能处理按key的请求
 }
 
 // 创建返回Handler
@@ -254,7 +625,7 @@ func NewDefaultResponseHandler() *ResponseHandler {
 // 初始化
 func (h *ResponseHandler) Init(handler *handler) {
 	h.handler = handler
-	h.requesterMap = make(map[IRequester]struct{})
+	h.requesters = nil
 }
 
 // 默认初始化
@@ -267,6 +638,16 @@ func (h *ResponseHandler) Close() {
 	h.handler.Close()
 }
 
+// 是否运行中，第一次Update后进入运行状态
+func (h *ResponseHandler) IsRunning() bool {
+	return h.handler.IsRunning()
+}
+
+// 关闭后的下一次Update完成时关闭的通道，没有Update过的在Close时关闭
+func (h *ResponseHandler) Done() <-chan struct{} {
+	return h.handler.Done()
+}
+
 // 创建请求者
 func (h *ResponseHandler) CreateRequester(receiver IRequestHandler, key interface{}, options ...RequestOption) IRequester {
 	return NewRequester(h, receiver, key, options...)
@@ -274,34 +655,46 @@ func (h *ResponseHandler) CreateRequester(receiver IRequestHandler, key interfac
 
 // 添加请求者
 func (h *ResponseHandler) addRequester(req IRequester) {
-	h.requesterMap[req] = struct{}{}
+	h.requesters = append(h.requesters, req)
 }
 
-// 发送
-func (h *ResponseHandler) Send(msgId uint32, args interface{}) error {
-	m := getMsg()
-	m.typ = msgNormal
-	m.id = msgId
-	m.args = args
+// 添加定时器
+func (h *ResponseHandler) AfterFunc(d time.Duration, f func()) *Timer {
+	return h.timers.add(h.now(), d, f)
+}
+
+// 执行到期的定时器
+func (h *ResponseHandler) runTimers(now time.Time) {
+	h.timers.run(now)
+}
+
+// 分配请求序号
+func (h *ResponseHandler) nextRequestId() uint64 {
+	return atomic.AddUint64(&h.requestId, 1)
+}
+
+// 发送构造好的消息
+func (h *ResponseHandler) send(m *msg) error {
 	return h.handler.Send(m)
 }
 
-// 转发消息
-func (h *ResponseHandler) forward(fromSender ISender, fromKey interface{}, msgId uint32, args interface{}) error {
+// 发送
+func (h *ResponseHandler) Send(msgId uint32, args interface{}, options ...SendOption) error {
 	m := getMsg()
-	m.typ = msgForward
+	m.typ = msgReply
 	m.id = msgId
-	m.sender = fromSender
-	m.fromKey = fromKey
 	m.args = args
+	m.headers = sendHeaders(nil, options)
 	return h.handler.Send(m)
 }
 
-// 更新处理IRequester的回调
+// 更新处理IRequester的回调和到期的定时器
 func (h *ResponseHandler) Update() error {
-	if h.handler.closed {
+	if h.handler.IsClosed() {
+		h.handler.stop()
 		return ErrClosed
 	}
+	h.handler.start()
 	loop := true
 	for loop {
 		select {
@@ -309,23 +702,45 @@ func (h *ResponseHandler) Update() error {
 			if !o {
 				return ErrClosed
 			}
-			h.handleResp(m)
+			h.processResp(m)
 		case <-h.handler.chClose:
-			h.handler.closed = true
+			h.handler.stop()
 			loop = false
 		default:
 			loop = false
 		}
 	}
+	h.runTimers(h.now())
 	return nil
 }
 
-// 处理返回
-func (r *ResponseHandler) handleResp(m *msg) {
-	for k := range r.requesterMap {
+// 处理返回，没有requester处理的作为死信，消息在这里回收
+func (h *ResponseHandler) processResp(m *msg) {
+	if h.recorder != nil {
+		recordMsg(h.recorder, h.handler.name, m)
+	}
+	if info := h.handler.beginMsgLog(m); info != nil {
+		defer h.handler.endMsgLog(info)
+	}
+	span := beginHandleSpan(h.handler, m)
+	if !h.handleResp(m) {
+		h.handler.logMsg(LogLevelWarn, LogEventUnclaimedResponse, m)
+		publishDeadLetter(DeadLetterUnclaimedResponse, m, nil)
+	}
+	endHandleSpan(h.handler, span)
+	putMsg(m)
+}
+
+// 处理返回，不回收消息，由调用者回收
+func (r *ResponseHandler) handleResp(m *msg) bool {
+	r.handler.headers = m.headers
+	defer func() {
+		r.handler.headers = nil
+	}()
+	for _, k := range r.requesters {
 		if k.handle(m) {
-			break
+			return true
 		}
 	}
-	putMsg(m)
+	return false
 }
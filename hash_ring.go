@@ -0,0 +1,96 @@
+package gproc
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+const (
+	HashRingReplicas = 100 // 一致性哈希每个节点的虚拟节点数
+)
+
+// 一致性哈希环，非线程安全，由使用者加锁
+type hashRing struct {
+	replicas int
+	hashes   []uint32
+	owners   map[uint32]string
+}
+
+// 创建哈希环
+func newHashRing(replicas int) *hashRing {
+	if replicas <= 0 {
+		replicas = HashRingReplicas
+	}
+	return &hashRing{
+		replicas: replicas,
+		owners:   make(map[uint32]string),
+	}
+}
+
+// 添加节点
+func (r *hashRing) add(node string) {
+	for i := 0; i < r.replicas; i++ {
+		h := hashString(node + "#" + strconv.Itoa(i))
+		if _, o := r.owners[h]; o {
+			continue
+		}
+		r.owners[h] = node
+		r.hashes = append(r.hashes, h)
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// 删除节点
+func (r *hashRing) remove(node string) {
+	hashes := r.hashes[:0]
+	for _, h := range r.hashes {
+		if r.owners[h] == node {
+			delete(r.owners, h)
+			continue
+		}
+		hashes = append(hashes, h)
+	}
+	r.hashes = hashes
+}
+
+// 获取key所在的节点
+func (r *hashRing) get(key interface{}) (string, bool) {
+	if len(r.hashes) == 0 {
+		return "", false
+	}
+	h := hashKey(key)
+	idx := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if idx == len(r.hashes) {
+		idx = 0
+	}
+	return r.owners[r.hashes[idx]], true
+}
+
+// 字符串哈希
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// key的哈希
+func hashKey(key interface{}) uint32 {
+	switch k := key.(type) {
+	case string:
+		return hashString(k)
+	case int:
+		return hashString(strconv.FormatInt(int64(k), 10))
+	case int32:
+		return hashString(strconv.FormatInt(int64(k), 10))
+	case int64:
+		return hashString(strconv.FormatInt(k, 10))
+	case uint32:
+		return hashString(strconv.FormatUint(uint64(k), 10))
+	case uint64:
+		return hashString(strconv.FormatUint(k, 10))
+	default:
+		return hashString(fmt.Sprint(k))
+	}
+}
@@ -0,0 +1,91 @@
+package gproc
+
+// 消息头，字符串键值对，用于区域、认证令牌、跟踪id、优先级等
+// 随消息传递后不能再修改，需要修改时复制
+type Headers map[string]string
+
+// 获取值
+func (h Headers) Get(key string) string {
+	return h[key]
+}
+
+// 复制
+func (h Headers) Clone() Headers {
+	if h == nil {
+		return nil
+	}
+	c := make(Headers, len(h))
+	for k, v := range h {
+		c[k] = v
+	}
+	return c
+}
+
+// 发送选项结构
+type SendOptions struct {
+	headers Headers
+}
+
+// 设置消息头
+func (options *SendOptions) SetHeader(key, value string) {
+	if options.headers == nil {
+		options.headers = make(Headers)
+	}
+	options.headers[key] = value
+}
+
+// 发送选项，用于Request、RequestForward、Send和Notify
+type SendOption func(*SendOptions)
+
+// 设置一个消息头
+func WithHeader(key, value string) SendOption {
+	return func(options *SendOptions) {
+		options.SetHeader(key, value)
+	}
+}
+
+// 设置多个消息头
+func WithHeaders(headers Headers) SendOption {
+	return func(options *SendOptions) {
+		for k, v := range headers {
+			options.SetHeader(k, v)
+		}
+	}
+}
+
+// 在base的基础上应用发送选项，没有选项时直接返回base，有选项时返回新的消息头
+func sendHeaders(base Headers, options []SendOption) Headers {
+	if len(options) == 0 {
+		return base
+	}
+	var o SendOptions
+	for _, option := range options {
+		option(&o)
+	}
+	if len(o.headers) == 0 {
+		return base
+	}
+	if len(base) == 0 {
+		return o.headers
+	}
+	headers := base.Clone()
+	for k, v := range o.headers {
+		headers[k] = v
+	}
+	return headers
+}
+
+// 正在处理的消息的消息头，只能在处理消息的goroutine中调用
+func (s *LocalService) Headers() Headers {
+	return s.handler.headers
+}
+
+// 正在处理的消息的消息头，只能在处理消息的goroutine中调用
+func (h *RequestHandler) Headers() Headers {
+	return h.handler.headers
+}
+
+// 正在处理的返回的消息头，只能在回调中调用
+func (h *ResponseHandler) Headers() Headers {
+	return h.handler.headers
+}
@@ -0,0 +1,86 @@
+package gproc
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestHeadersRequestAndReply(t *testing.T) {
+	service := NewDefaultLocalService()
+	var requestLocale string
+	service.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+		requestLocale = service.Headers().Get("locale")
+		sender.Send(MsgIdBuyItem, args, WithHeader("priority", "high"))
+		service.Notify(int32(1), MsgIdUpdateFriendInfo, nil, WithHeader("auth", "token"))
+	})
+	go service.Run()
+	defer service.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(service, int32(1))
+	var reply, notify Headers
+	r.RegisterCallback(MsgIdBuyItem, func(args interface{}) {
+		reply = p.Headers()
+	})
+	r.RegisterNotify(MsgIdUpdateFriendInfo, func(args interface{}) {
+		notify = p.Headers()
+	})
+	if err := r.Request(MsgIdBuyItem, nil, WithHeader("locale", "zh-CN")); err != nil {
+		t.Fatalf("request err: %v", err)
+	}
+	waitUntil(t, func() bool { return reply != nil && notify != nil }, p)
+	if requestLocale != "zh-CN" {
+		t.Fatalf("handler expect locale header, got %q", requestLocale)
+	}
+	// 返回带上请求的消息头和返回自己的消息头
+	if reply.Get("locale") != "zh-CN" || reply.Get("priority") != "high" {
+		t.Fatalf("unexpected reply headers %v", reply)
+	}
+	if len(notify) != 1 || notify.Get("auth") != "token" {
+		t.Fatalf("unexpected notify headers %v", notify)
+	}
+	if p.Headers() != nil {
+		t.Fatalf("headers expect nil outside callback")
+	}
+}
+
+func TestHeadersForward(t *testing.T) {
+	service := NewDefaultRequestHandler()
+	go service.Run()
+	defer service.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r1 := p.CreateRequester(service, int32(1))
+	r2 := p.CreateRequester(service, int32(2))
+	var headers Headers
+	r2.RegisterForward(MsgIdUpdateFriendInfo, func(fromKey interface{}, args interface{}) {
+		headers = p.Headers()
+	})
+	r1.RequestForward(int32(2), MsgIdUpdateFriendInfo, nil, WithHeaders(Headers{"trace": "abc", "locale": "en"}))
+	waitUntil(t, func() bool { return headers != nil }, p)
+	if headers.Get("trace") != "abc" || headers.Get("locale") != "en" {
+		t.Fatalf("unexpected forwarded headers %v", headers)
+	}
+}
+
+func TestHeadersTraceFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "headers.trace")
+	trace, err := CreateTraceFile(path, NewCodecRegistry(nil))
+	if err != nil {
+		t.Fatalf("create trace err: %v", err)
+	}
+	m := &msg{typ: msgNormal, id: MsgIdBuyItem, headers: Headers{"locale": "zh-CN"}}
+	recordMsg(trace, "shop", m)
+	if err := trace.Close(); err != nil {
+		t.Fatalf("close trace err: %v", err)
+	}
+	events, err := ReadTraceFile(path, nil)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("read trace err: %v, events %v", err, len(events))
+	}
+	if events[0].Headers.Get("locale") != "zh-CN" {
+		t.Fatalf("unexpected headers %v", events[0].Headers)
+	}
+}
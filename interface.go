@@ -1,25 +1,35 @@
 package gproc
 
+import (
+	"time"
+)
+
 // 发送者接口
 type ISender interface {
 	// 发送普通消息
-	Send(msgId uint32, args interface{}) error
-	// 转发消息
-	forward(fromSender ISender, fromKey interface{}, msgId uint32, args interface{}) error
+	Send(msgId uint32, args interface{}, options ...SendOption) error
+	// 发送构造好的消息，用于转发、带请求序号的返回和带span的通知
+	send(m *msg) error
 }
 
 // 请求者接口
 type IRequester interface {
 	// 请求
-	Request(msgId uint32, args interface{}) error
+	Request(msgId uint32, args interface{}, options ...SendOption) error
 	// 请求转发
-	RequestForward(toKey interface{}, msgId uint32, args interface{}) error
+	RequestForward(toKey interface{}, msgId uint32, args interface{}, options ...SendOption) error
 	// 注册请求回调
 	RegisterCallback(msgId uint32, callback func(interface{}))
 	// 注册通知处理器
 	RegisterNotify(msgId uint32, handler func(interface{}))
 	// 注册转发处理器
 	RegisterForward(msgId uint32, handle func(fromKey interface{}, args interface{}))
+	// 分散聚合请求
+	Gather(targets []IRequestHandler, msgId uint32, args interface{}, timeout time.Duration, done func([]GatherResult))
+	// 按key分散聚合请求
+	GatherKeys(keys []interface{}, resolve GatherResolver, msgId uint32, args interface{}, timeout time.Duration, done func([]GatherResult))
+	// 流式请求
+	RequestStream(msgId uint32, args interface{}, window int, onChunk func(msgId uint32, chunk interface{}), onEnd func(err error)) error
 	// 处理返回
 	handle(m *msg) bool
 }
@@ -43,6 +53,12 @@ type IResponseHandler interface {
 	CreateRequester(receiver IRequestHandler, key interface{}, options ...RequestOption) IRequester
 	// 更新
 	Update() error
+	// 添加定时器，只能在持有者的goroutine中调用，到期后也在持有者的goroutine中执行
+	AfterFunc(d time.Duration, f func()) *Timer
 	// 添加请求者
 	addRequester(req IRequester)
+	// 分配请求序号
+	nextRequestId() uint64
+	// 正在处理的返回的span上下文
+	currentSpan() SpanContext
 }
@@ -0,0 +1,350 @@
+package gproc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	JournalDir           = "journal"        // 默认的日志目录
+	JournalMaxRecordSize = 64 * 1024 * 1024 // 单条记录的最大长度
+)
+
+// 日志记录
+type JournalRecord struct {
+	Seq    uint64 // 序号，从1开始递增
+	TypeId uint32 // 数据在编解码注册表中的类型id
+	Data   []byte // 编码后的数据
+}
+
+// 事件日志接口，按持久化id分开存放事件和快照
+type IJournal interface {
+	// 追加事件
+	Append(id string, record JournalRecord) error
+	// 按顺序重放序号不小于fromSeq的事件
+	Replay(id string, fromSeq uint64, handle func(record JournalRecord) error) error
+	// 保存快照，只保留最新的
+	SaveSnapshot(id string, record JournalRecord) error
+	// 加载最新的快照
+	LoadSnapshot(id string) (JournalRecord, bool, error)
+	// 关闭
+	Close() error
+}
+
+// 内存日志，进程内有效，一般用于测试
+type MemoryJournal struct {
+	locker    sync.RWMutex
+	events    map[string][]JournalRecord
+	snapshots map[string]JournalRecord
+}
+
+// 创建内存日志
+func NewMemoryJournal() *MemoryJournal {
+	return &MemoryJournal{
+		events:    make(map[string][]JournalRecord),
+		snapshots: make(map[string]JournalRecord),
+	}
+}
+
+// 追加事件
+func (j *MemoryJournal) Append(id string, record JournalRecord) error {
+	j.locker.Lock()
+	defer j.locker.Unlock()
+	j.events[id] = append(j.events[id], record)
+	return nil
+}
+
+// 重放事件
+func (j *MemoryJournal) Replay(id string, fromSeq uint64, handle func(record JournalRecord) error) error {
+	j.locker.RLock()
+	events := j.events[id]
+	j.locker.RUnlock()
+	for _, record := range events {
+		if record.Seq < fromSeq {
+			continue
+		}
+		if err := handle(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 保存快照
+func (j *MemoryJournal) SaveSnapshot(id string, record JournalRecord) error {
+	j.locker.Lock()
+	defer j.locker.Unlock()
+	j.snapshots[id] = record
+	return nil
+}
+
+// 加载快照
+func (j *MemoryJournal) LoadSnapshot(id string) (JournalRecord, bool, error) {
+	j.locker.RLock()
+	defer j.locker.RUnlock()
+	record, o := j.snapshots[id]
+	return record, o, nil
+}
+
+// 关闭
+func (j *MemoryJournal) Close() error {
+	return nil
+}
+
+// 文件日志，每个持久化id一个只追加的事件文件和一个快照文件
+// 记录格式: 长度(4) crc32(4) 序号(8) 类型id(4) 数据，崩溃留下的不完整尾部记录被忽略，
+// 后面还有数据的损坏记录返回ErrJournalCorrupted，不会截掉之后完整的记录
+type FileJournal struct {
+	locker sync.Mutex
+	dir    string
+	sync   bool
+	files  map[string]*os.File
+}
+
+// 创建文件日志，sync为true时每次追加后同步到磁盘
+func NewFileJournal(dir string, sync bool) (*FileJournal, error) {
+	if dir == "" {
+		dir = JournalDir
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileJournal{
+		dir:   dir,
+		sync:  sync,
+		files: make(map[string]*os.File),
+	}, nil
+}
+
+// 事件文件路径
+func (j *FileJournal) eventPath(id string) string {
+	return filepath.Join(j.dir, id+".journal")
+}
+
+// 快照文件路径
+func (j *FileJournal) snapshotPath(id string) string {
+	return filepath.Join(j.dir, id+".snapshot")
+}
+
+// 追加事件
+func (j *FileJournal) Append(id string, record JournalRecord) error {
+	j.locker.Lock()
+	defer j.locker.Unlock()
+	f, o := j.files[id]
+	if !o {
+		var err error
+		f, err = j.openAppend(id)
+		if err != nil {
+			return err
+		}
+		j.files[id] = f
+	}
+	if _, err := f.Write(encodeJournalRecord(record)); err != nil {
+		return err
+	}
+	if j.sync {
+		return f.Sync()
+	}
+	return nil
+}
+
+// 打开事件文件用于追加，先截掉不完整的尾部记录，避免新记录接在损坏的数据后面
+func (j *FileJournal) openAppend(id string) (*os.File, error) {
+	path := j.eventPath(id)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	valid, err := scanJournal(f, nil)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// 重放事件
+func (j *FileJournal) Replay(id string, fromSeq uint64, handle func(record JournalRecord) error) error {
+	f, err := os.Open(j.eventPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	_, err = scanJournal(f, func(record JournalRecord) error {
+		if record.Seq < fromSeq {
+			return nil
+		}
+		return handle(record)
+	})
+	return err
+}
+
+// 从头读取事件文件，返回完整记录的总长度，handle不为nil时按顺序处理每条记录
+// 不完整的尾部记录被忽略，损坏的记录后面还有数据时返回ErrJournalCorrupted
+func scanJournal(f *os.File, handle func(record JournalRecord) error) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	r := bufio.NewReader(f)
+	var valid int64
+	for {
+		record, err := decodeJournalRecord(r)
+		if err != nil {
+			// 进程崩溃时可能留下不完整的尾部记录
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return valid, nil
+			}
+			if err == ErrJournalCorrupted {
+				torn, e := isTornTail(f, valid, info.Size())
+				if e != nil {
+					return valid, e
+				}
+				if torn {
+					return valid, nil
+				}
+			}
+			return valid, err
+		}
+		valid += int64(8 + 12 + len(record.Data))
+		if handle != nil {
+			if err := handle(record); err != nil {
+				return valid, err
+			}
+		}
+	}
+}
+
+// offset处损坏的记录是否是不完整的尾部，记录的长度延伸到文件末尾或者之后全是0(文件系统预分配的空间)
+func isTornTail(f *os.File, offset int64, size int64) (bool, error) {
+	var header [4]byte
+	if _, err := f.ReadAt(header[:], offset); err != nil {
+		return false, err
+	}
+	if offset+8+int64(binary.BigEndian.Uint32(header[:])) >= size {
+		return true, nil
+	}
+	buf := make([]byte, 4096)
+	for offset < size {
+		n, err := f.ReadAt(buf, offset)
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		offset += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// 保存快照，先写临时文件并同步到磁盘再改名，保证崩溃后快照完整
+func (j *FileJournal) SaveSnapshot(id string, record JournalRecord) error {
+	path := j.snapshotPath(id)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(encodeJournalRecord(record))
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// 加载快照
+func (j *FileJournal) LoadSnapshot(id string) (JournalRecord, bool, error) {
+	f, err := os.Open(j.snapshotPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return JournalRecord{}, false, nil
+		}
+		return JournalRecord{}, false, err
+	}
+	defer f.Close()
+	record, err := decodeJournalRecord(bufio.NewReader(f))
+	if err != nil {
+		return JournalRecord{}, false, err
+	}
+	return record, true, nil
+}
+
+// 关闭打开的事件文件
+func (j *FileJournal) Close() error {
+	j.locker.Lock()
+	defer j.locker.Unlock()
+	var err error
+	for id, f := range j.files {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(j.files, id)
+	}
+	return err
+}
+
+// 编码记录
+func encodeJournalRecord(record JournalRecord) []byte {
+	size := 12 + len(record.Data)
+	buf := make([]byte, 8+size)
+	body := buf[8:]
+	binary.BigEndian.PutUint64(body[0:8], record.Seq)
+	binary.BigEndian.PutUint32(body[8:12], record.TypeId)
+	copy(body[12:], record.Data)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(size))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(body))
+	return buf
+}
+
+// 解码记录
+func decodeJournalRecord(r io.Reader) (JournalRecord, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return JournalRecord{}, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size < 12 || size > JournalMaxRecordSize {
+		return JournalRecord{}, ErrJournalCorrupted
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return JournalRecord{}, err
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+		return JournalRecord{}, ErrJournalCorrupted
+	}
+	return JournalRecord{
+		Seq:    binary.BigEndian.Uint64(body[0:8]),
+		TypeId: binary.BigEndian.Uint32(body[8:12]),
+		Data:   body[12:],
+	}, nil
+}
@@ -0,0 +1,158 @@
+package gproc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHandlerLifecycle(t *testing.T) {
+	h := NewDefaultRequestHandler()
+	if h.IsRunning() {
+		t.Fatal("handler running before Run")
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- h.Run()
+	}()
+	waitUntil(t, h.IsRunning)
+	if err := h.Run(); err != ErrAlreadyRunning {
+		t.Fatalf("run twice expect ErrAlreadyRunning, got %v", err)
+	}
+
+	h.Close()
+	select {
+	case <-h.Done():
+	case <-time.After(time.Second):
+		t.Fatal("done not closed after close")
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("run return err: %v", err)
+	}
+	if h.IsRunning() {
+		t.Fatal("handler running after close")
+	}
+	if err := h.recv(getMsg()); err != ErrClosed {
+		t.Fatalf("send after close expect ErrClosed, got %v", err)
+	}
+	if err := h.Run(); err != ErrClosed {
+		t.Fatalf("run after close expect ErrClosed, got %v", err)
+	}
+}
+
+func TestHandlerSendUnblockOnClose(t *testing.T) {
+	s := NewLocalService(1)
+	// 处理函数阻塞住服务，让通道满
+	block := make(chan struct{})
+	s.RegisterHandle(MsgIdGetItemList, func(ISender, interface{}) {
+		<-block
+	})
+	go s.Run()
+	waitUntil(t, s.IsRunning)
+
+	p := NewDefaultResponseHandler()
+	r := p.CreateRequester(s, 1)
+	r.Request(MsgIdGetItemList, nil)
+	r.Request(MsgIdGetItemList, nil)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- r.Request(MsgIdGetItemList, nil)
+	}()
+	select {
+	case err := <-result:
+		t.Fatalf("send to full mailbox return early: %v", err)
+	case <-time.After(time.Millisecond * 20):
+	}
+
+	s.Close()
+	select {
+	case err := <-result:
+		if err != ErrClosed {
+			t.Fatalf("blocked send expect ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked send not return after close")
+	}
+	close(block)
+	<-s.Done()
+
+	// 没有Update过的ResponseHandler关闭后直接停止
+	p.Close()
+	select {
+	case <-p.Done():
+	default:
+		t.Fatal("response handler done not closed")
+	}
+}
+
+func TestHandlerSendUnblockOnPanic(t *testing.T) {
+	h := NewRequestHandler(newHandler(1))
+	block := make(chan struct{})
+	h.RegisterHandle(MsgIdGetItemList, func(ISender, interface{}) {
+		<-block
+		panic("boom")
+	})
+	go func() {
+		defer func() {
+			recover()
+		}()
+		h.Run()
+	}()
+	waitUntil(t, h.IsRunning)
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(h, 1)
+	r.Request(MsgIdGetItemList, nil)
+	waitUntil(t, func() bool { return len(h.handler.ch) == 0 })
+	r.Request(MsgIdGetItemList, nil)
+	result := make(chan error, 1)
+	go func() {
+		result <- r.Request(MsgIdGetItemList, nil)
+	}()
+	// 处理循环panic退出，没有调用Close，等待中的发送也要返回
+	close(block)
+	select {
+	case err := <-result:
+		if err != nil && err != ErrClosed {
+			t.Fatalf("blocked send expect ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked send not return after loop exit")
+	}
+	<-h.Done()
+	if err := r.Request(MsgIdGetItemList, nil); err != ErrClosed {
+		t.Fatalf("send after loop exit expect ErrClosed, got %v", err)
+	}
+}
+
+func TestHandlerSendDuringStop(t *testing.T) {
+	// 和停止同时进行的发送不会把消息留在已经清理过的通道中
+	for i := 0; i < 10; i++ {
+		h := newHandler(1 << 16)
+		h.start()
+		var wg sync.WaitGroup
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					m := getMsg()
+					m.typ = msgExec
+					m.args = func() {}
+					if h.Send(m) != nil {
+						return
+					}
+				}
+			}()
+		}
+		waitUntil(t, func() bool { return len(h.ch) > 0 })
+		h.Close()
+		h.stop()
+		wg.Wait()
+		if n := len(h.ch); n != 0 {
+			t.Fatalf("%v messages left in stopped mailbox", n)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package gproc
+
+import (
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SlowHandlerThreshold = time.Duration(100 * time.Millisecond) // 默认的慢处理阈值
+)
+
+// 日志级别
+type LogLevel int32
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+var logLevelNames = []string{
+	LogLevelDebug: "debug",
+	LogLevelInfo:  "info",
+	LogLevelWarn:  "warn",
+	LogLevelError: "error",
+}
+
+func (l LogLevel) String() string {
+	if l < 0 || int(l) >= len(logLevelNames) {
+		return "unknown"
+	}
+	return logLevelNames[l]
+}
+
+// 日志事件
+const (
+	LogEventSignup            = "signup"             // 请求者报名
+	LogEventSignoff           = "signoff"            // 请求者注销
+	LogEventUnhandled         = "unhandled_message"  // 请求没有注册处理函数
+	LogEventUnclaimedResponse = "unclaimed_response" // 返回没有requester处理
+	LogEventForwardFailed     = "forward_failed"     // 转发失败
+	LogEventNotifyFailed      = "notify_failed"      // 转交的通知失败
+	LogEventMsgFailed         = "message_failed"     // 其他处理失败
+	LogEventClosed            = "closed"             // 处理循环退出
+	LogEventPanic             = "panic"              // 处理消息时panic，记录后继续panic
+	LogEventSlowHandler       = "slow_handler"       // 处理消息超过阈值
+	LogEventSnapshotFailed    = "snapshot_failed"    // 自动保存快照失败
+)
+
+// 日志字段
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// 日志接口，可能在多个goroutine中同时调用，需要线程安全
+type Logger interface {
+	Log(level LogLevel, event string, fields ...LogField)
+}
+
+var logger atomic.Value
+
+// 设置全局日志，没有单独设置日志的服务使用，nil表示不输出
+func SetLogger(l Logger) {
+	logger.Store(&l)
+}
+
+// 获取全局日志，没有设置时返回nil
+func GetLogger() Logger {
+	l, _ := logger.Load().(*Logger)
+	if l == nil {
+		return nil
+	}
+	return *l
+}
+
+// 设置服务的日志，不设置时使用全局日志，在Run之前调用
+func (s *LocalService) SetLogger(l Logger) {
+	s.handler.logger = l
+}
+
+// 设置慢处理阈值，处理一条消息超过这个时间时输出slow_handler，<0表示不检查，在Run之前调用
+func (s *LocalService) SetSlowHandlerThreshold(d time.Duration) {
+	s.handler.slowThreshold = d
+}
+
+// 设置日志，不设置时使用全局日志，在Run之前调用
+func (h *RequestHandler) SetLogger(l Logger) {
+	h.handler.logger = l
+}
+
+// 设置慢处理阈值，<0表示不检查，在Run之前调用
+func (h *RequestHandler) SetSlowHandlerThreshold(d time.Duration) {
+	h.handler.slowThreshold = d
+}
+
+// 设置日志，不设置时使用全局日志，在Update之前调用
+func (h *ResponseHandler) SetLogger(l Logger) {
+	h.handler.logger = l
+}
+
+// 设置慢回调阈值，<0表示不检查，在Update之前调用
+func (h *ResponseHandler) SetSlowHandlerThreshold(d time.Duration) {
+	h.handler.slowThreshold = d
+}
+
+// 使用的日志
+func (h *handler) getLogger() Logger {
+	if h.logger != nil {
+		return h.logger
+	}
+	return GetLogger()
+}
+
+// 输出处理器的事件
+func (h *handler) log(level LogLevel, event string, fields ...LogField) {
+	l := h.getLogger()
+	if l == nil {
+		return
+	}
+	l.Log(level, event, append([]LogField{{Key: "service", Value: h.name}}, fields...)...)
+}
+
+// 消息的日志字段
+func msgLogFields(typ msgType, msgId uint32, fromKey, toKey interface{}) []LogField {
+	fields := []LogField{{Key: "msg_type", Value: typ.String()}, {Key: "msg_id", Value: msgId}}
+	if fromKey != nil {
+		fields = append(fields, LogField{Key: "from_key", Value: fromKey})
+	}
+	if toKey != nil {
+		fields = append(fields, LogField{Key: "to_key", Value: toKey})
+	}
+	return fields
+}
+
+// 输出消息相关的事件
+func (h *handler) logMsg(level LogLevel, event string, m *msg, fields ...LogField) {
+	if h.getLogger() == nil {
+		return
+	}
+	h.log(level, event, append(msgLogFields(m.typ, m.id, m.fromKey, m.toKey), fields...)...)
+}
+
+// 输出消息处理失败
+func (h *handler) logMsgError(m *msg, err error) {
+	event := LogEventMsgFailed
+	switch {
+	case err == ErrNotFoundRequestHandle:
+		event = LogEventUnhandled
+	case m.typ == msgForward || m.typ == msgRemoteForward || m.typ == msgKeyRequest:
+		event = LogEventForwardFailed
+	case m.typ == msgRemoteNotify:
+		event = LogEventNotifyFailed
+	}
+	h.logMsg(LogLevelWarn, event, m, LogField{Key: "error", Value: err})
+}
+
+// 正在处理的消息，消息处理后会被回收，需要的字段先取出
+type msgLogInfo struct {
+	typ     msgType
+	id      uint32
+	fromKey interface{}
+	toKey   interface{}
+	start   time.Time
+}
+
+// 开始处理消息，没有日志时返回nil
+func (h *handler) beginMsgLog(m *msg) *msgLogInfo {
+	if h.getLogger() == nil {
+		return nil
+	}
+	return &msgLogInfo{typ: m.typ, id: m.id, fromKey: m.fromKey, toKey: m.toKey, start: time.Now()}
+}
+
+// 结束处理消息，由defer调用，输出panic和慢处理，panic记录后继续
+func (h *handler) endMsgLog(info *msgLogInfo) {
+	if r := recover(); r != nil {
+		fields := msgLogFields(info.typ, info.id, info.fromKey, info.toKey)
+		h.log(LogLevelError, LogEventPanic, append(fields, LogField{Key: "panic", Value: r}, LogField{Key: "stack", Value: string(debug.Stack())})...)
+		panic(r)
+	}
+	threshold := h.slowThreshold
+	if threshold == 0 {
+		threshold = SlowHandlerThreshold
+	}
+	if threshold < 0 {
+		return
+	}
+	if d := time.Since(info.start); d >= threshold {
+		fields := msgLogFields(info.typ, info.id, info.fromKey, info.toKey)
+		h.log(LogLevelWarn, LogEventSlowHandler, append(fields, LogField{Key: "duration", Value: d})...)
+	}
+}
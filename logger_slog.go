@@ -0,0 +1,46 @@
+//go:build go1.21
+// +build go1.21
+
+package gproc
+
+import (
+	"context"
+	"log/slog"
+)
+
+// log/slog适配的日志，事件作为消息，字段作为属性
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+// 创建slog适配的日志，l为nil时使用slog.Default()
+func NewSlogLogger(l *slog.Logger) *SlogLogger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &SlogLogger{logger: l}
+}
+
+// 输出
+func (l *SlogLogger) Log(level LogLevel, event string, fields ...LogField) {
+	var slevel slog.Level
+	switch level {
+	case LogLevelDebug:
+		slevel = slog.LevelDebug
+	case LogLevelInfo:
+		slevel = slog.LevelInfo
+	case LogLevelWarn:
+		slevel = slog.LevelWarn
+	default:
+		slevel = slog.LevelError
+	}
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, slevel) {
+		return
+	}
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, f := range fields {
+		attrs = append(attrs, slog.Any(f.Key, f.Value))
+	}
+	l.logger.LogAttrs(ctx, slevel, event, attrs...)
+}
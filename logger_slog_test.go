@@ -0,0 +1,28 @@
+//go:build go1.21
+// +build go1.21
+
+package gproc
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var b bytes.Buffer
+	logger := NewSlogLogger(slog.New(slog.NewJSONHandler(&b, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	logger.Log(LogLevelDebug, LogEventSignup, LogField{Key: "service", Value: "shop"})
+	if b.Len() != 0 {
+		t.Fatalf("debug expect filtered, got %v", b.String())
+	}
+	logger.Log(LogLevelWarn, LogEventUnhandled, LogField{Key: "service", Value: "shop"}, LogField{Key: "msg_id", Value: uint32(2)})
+	var record map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &record); err != nil {
+		t.Fatalf("unmarshal err: %v", err)
+	}
+	if record["level"] != "WARN" || record["msg"] != LogEventUnhandled || record["service"] != "shop" || record["msg_id"] != float64(2) {
+		t.Fatalf("unexpected record %v", record)
+	}
+}
@@ -0,0 +1,109 @@
+package gproc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type logEntry struct {
+	level  LogLevel
+	event  string
+	fields map[string]interface{}
+}
+
+// 记录日志的测试日志
+type memoryLogger struct {
+	locker  sync.Mutex
+	entries []logEntry
+}
+
+func (l *memoryLogger) Log(level LogLevel, event string, fields ...LogField) {
+	e := logEntry{level: level, event: event, fields: make(map[string]interface{})}
+	for _, f := range fields {
+		e.fields[f.Key] = f.Value
+	}
+	l.locker.Lock()
+	l.entries = append(l.entries, e)
+	l.locker.Unlock()
+}
+
+func (l *memoryLogger) find(event string) (logEntry, bool) {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	for _, e := range l.entries {
+		if e.event == event {
+			return e, true
+		}
+	}
+	return logEntry{}, false
+}
+
+func TestServiceLogger(t *testing.T) {
+	logger := &memoryLogger{}
+	service := NewDefaultLocalService()
+	service.SetName("shop")
+	service.SetLogger(logger)
+	service.SetSlowHandlerThreshold(time.Millisecond)
+	service.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+		time.Sleep(time.Millisecond * 5)
+	})
+	go service.Run()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(service, int32(1001))
+	r.Request(MsgIdBuyItem, nil)
+	r.Request(MsgIdGetItemList, nil)
+	r.RequestForward(int32(1002), MsgIdUpdateFriendInfo, nil)
+	waitUntil(t, func() bool {
+		_, o := logger.find(LogEventForwardFailed)
+		return o
+	})
+	service.Close()
+	<-service.Done()
+
+	if e, o := logger.find(LogEventSignup); !o || e.level != LogLevelDebug || e.fields["service"] != "shop" || e.fields["from_key"] != int32(1001) {
+		t.Fatalf("unexpected signup log %+v", e)
+	}
+	if e, o := logger.find(LogEventSlowHandler); !o || e.fields["msg_id"] != uint32(MsgIdBuyItem) || e.fields["duration"].(time.Duration) < time.Millisecond {
+		t.Fatalf("unexpected slow handler log %+v", e)
+	}
+	if e, o := logger.find(LogEventUnhandled); !o || e.level != LogLevelWarn || e.fields["msg_id"] != uint32(MsgIdGetItemList) || e.fields["error"] != ErrNotFoundRequestHandle {
+		t.Fatalf("unexpected unhandled log %+v", e)
+	}
+	if e, _ := logger.find(LogEventForwardFailed); e.fields["to_key"] != int32(1002) {
+		t.Fatalf("unexpected forward failed log %+v", e)
+	}
+	if e, o := logger.find(LogEventClosed); !o || e.fields["service"] != "shop" {
+		t.Fatalf("unexpected closed log %+v", e)
+	}
+}
+
+func TestGlobalLoggerPanic(t *testing.T) {
+	logger := &memoryLogger{}
+	SetLogger(logger)
+	defer SetLogger(nil)
+
+	h := NewDefaultRequestHandler()
+	h.SetName("bag")
+	h.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+		panic("boom")
+	})
+	m := getMsg()
+	m.typ = msgNormal
+	m.id = MsgIdBuyItem
+	m.fromKey = int32(1)
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("panic expect to continue, got %v", r)
+			}
+		}()
+		h.processMsg(m)
+	}()
+	e, o := logger.find(LogEventPanic)
+	if !o || e.level != LogLevelError || e.fields["service"] != "bag" || e.fields["panic"] != "boom" || e.fields["stack"] == "" {
+		t.Fatalf("unexpected panic log %+v", e)
+	}
+}
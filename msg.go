@@ -11,8 +11,66 @@ const (
 	msgSignup  msgType = 1 // 报名
 	msgForward msgType = 2 // 转发
 	//msgNotify msgType = 3 // 通知
+	msgRemoteForward msgType = 4  // 其他节点转交过来的转发
+	msgRemoteNotify  msgType = 5  // 其他节点转交过来的通知
+	msgSignOff       msgType = 6  // 注销报名
+	msgExec          msgType = 7  // 在处理器的goroutine中执行函数
+	msgReply         msgType = 8  // 回复或通知，发给requester的持有者
+	msgForwarded     msgType = 9  // 转发给目标requester的持有者
+	msgStreamChunk   msgType = 10 // 流式返回的数据块
+	msgStreamEnd     msgType = 11 // 流式返回结束
+	msgKeyRequest    msgType = 12 // 按key的请求，由key报名的节点交给key的持有者处理
+	msgReplyError    msgType = 13 // 请求没能交给处理者，args是错误，发给发起者结束请求
 )
 
+// 是否发给requester持有者的消息，其他的都是发给请求处理器的
+func (t msgType) isResponse() bool {
+	return t == msgReply || t == msgForwarded || t == msgStreamChunk || t == msgStreamEnd || t == msgReplyError
+}
+
+// 消息类型名
+func (t msgType) String() string {
+	switch t {
+	case msgNormal:
+		return "normal"
+	case msgSignup:
+		return "signup"
+	case msgForward:
+		return "forward"
+	case msgRemoteForward:
+		return "remote_forward"
+	case msgRemoteNotify:
+		return "remote_notify"
+	case msgSignOff:
+		return "signoff"
+	case msgExec:
+		return "exec"
+	case msgReply:
+		return "reply"
+	case msgForwarded:
+		return "forwarded"
+	case msgStreamChunk:
+		return "stream_chunk"
+	case msgStreamEnd:
+		return "stream_end"
+	case msgKeyRequest:
+		return "key_request"
+	case msgReplyError:
+		return "reply_error"
+	}
+	return "unknown"
+}
+
+// 按名字解析消息类型
+func parseMsgType(name string) (msgType, bool) {
+	for t := msgNormal; t <= msgReplyError; t++ {
+		if t.String() == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
 // 消息
 type msg struct {
 	typ     msgType
@@ -21,6 +79,12 @@ type msg struct {
 	id      uint32
 	args    interface{}
 	sender  ISender
+	reqId   uint64      // 请求序号，需要匹配返回的请求才有
+	noWait  bool        // 通道满时不等待，返回ErrMailboxFull
+	tracked bool        // 请求的结果(返回或超时)会由请求者报告
+	walSeq  uint64      // 在持久邮箱中的序号，处理完后确认
+	span    SpanContext // 跟踪的span上下文
+	headers Headers     // 消息头
 }
 
 // 重置
@@ -31,6 +95,12 @@ func (m *msg) reset() {
 	m.id = 0
 	m.args = nil
 	m.sender = nil
+	m.reqId = 0
+	m.noWait = false
+	m.tracked = false
+	m.walSeq = 0
+	m.span = SpanContext{}
+	m.headers = nil
 }
 
 // 消息池结构
@@ -0,0 +1,76 @@
+package gproc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 观察到的发送
+type SentMsg struct {
+	Type       string      // 消息类型: reply、forwarded、stream_chunk、stream_end
+	MsgId      uint32      // 消息id
+	Args       interface{} // 数据，stream_end时是结束的错误
+	FromKey    interface{} // 转发的发起者key
+	FromSender ISender     // 转发的发起者，可以用来回复
+	Headers    Headers     // 消息头
+}
+
+// 发送观察者，实现IResponseHandler，所有发给它的消息都交给observe，不经过通道
+// 一般用于测试，作为处理函数的sender或者requester的持有者
+type sendObserver struct {
+	observe   func(SentMsg)
+	timers    timerQueue
+	requestId uint64
+}
+
+// 创建发送观察者，observe可能在不同的goroutine中调用
+func NewSendObserver(observe func(SentMsg)) IResponseHandler {
+	return &sendObserver{observe: observe}
+}
+
+// 发送
+func (o *sendObserver) Send(msgId uint32, args interface{}, options ...SendOption) error {
+	o.observe(SentMsg{Type: msgReply.String(), MsgId: msgId, Args: args, Headers: sendHeaders(nil, options)})
+	return nil
+}
+
+// 创建请求者
+func (o *sendObserver) CreateRequester(receiver IRequestHandler, key interface{}, options ...RequestOption) IRequester {
+	return NewRequester(o, receiver, key, options...)
+}
+
+// 没有通道，不需要更新，只执行到期的定时器
+func (o *sendObserver) Update() error {
+	o.timers.run(time.Now())
+	return nil
+}
+
+// 添加定时器
+func (o *sendObserver) AfterFunc(d time.Duration, f func()) *Timer {
+	return o.timers.add(time.Now(), d, f)
+}
+
+// 返回直接交给observe，不需要记录请求者
+func (o *sendObserver) addRequester(req IRequester) {
+}
+
+// 转发、带请求序号的返回和流式返回
+func (o *sendObserver) send(m *msg) error {
+	sent := SentMsg{Type: m.typ.String(), MsgId: m.id, Args: m.args, Headers: m.headers}
+	if m.typ == msgForwarded {
+		sent.FromKey, sent.FromSender = m.fromKey, m.sender
+	}
+	putMsg(m)
+	o.observe(sent)
+	return nil
+}
+
+// 分配请求序号
+func (o *sendObserver) nextRequestId() uint64 {
+	return atomic.AddUint64(&o.requestId, 1)
+}
+
+// 没有处理循环，没有当前span
+func (o *sendObserver) currentSpan() SpanContext {
+	return SpanContext{}
+}
@@ -2,7 +2,9 @@ package gproc
 
 // 请求选项结构
 type RequestOptions struct {
-	requestTimeout int32
+	requestTimeout int32                                           // 请求超时，毫秒
+	retryPolicy    *RetryPolicy                                    // 重试策略
+	failHandle     func(msgId uint32, args interface{}, err error) // 请求最终失败的处理函数
 }
 
 // 请求超时
@@ -10,11 +12,36 @@ func (options *RequestOptions) SetRequestTimeout(timeout int32) {
 	options.requestTimeout = timeout
 }
 
+// 重试策略
+func (options *RequestOptions) SetRetryPolicy(policy *RetryPolicy) {
+	options.retryPolicy = policy
+}
+
+// 请求最终失败的处理函数，超时或者重试次数用完时在持有者的goroutine中调用
+func (options *RequestOptions) SetFailHandle(handle func(msgId uint32, args interface{}, err error)) {
+	options.failHandle = handle
+}
+
 // 请求选项
-type RequestOption func(RequestOptions)
+type RequestOption func(*RequestOptions)
 
+// 请求超时，单位毫秒，超时后没有返回算作失败
 func RequestTimeout(timeout int32) RequestOption {
-	return func(options RequestOptions) {
+	return func(options *RequestOptions) {
 		options.SetRequestTimeout(timeout)
 	}
 }
+
+// 请求重试
+func RequestRetry(policy *RetryPolicy) RequestOption {
+	return func(options *RequestOptions) {
+		options.SetRetryPolicy(policy)
+	}
+}
+
+// 请求失败处理
+func RequestFailHandle(handle func(msgId uint32, args interface{}, err error)) RequestOption {
+	return func(options *RequestOptions) {
+		options.SetFailHandle(handle)
+	}
+}
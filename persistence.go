@@ -0,0 +1,197 @@
+package gproc
+
+// 事件溯源的持久化，服务声明事件类型和应用事件的函数，处理函数先把事件写入日志再应用到状态
+// 恢复时加载最新的快照，再重放快照之后的事件，非线程安全，只在服务的goroutine中使用
+type Persistence struct {
+	id               string
+	journal          IJournal
+	registry         *CodecRegistry
+	applies          map[uint32]func(event interface{})
+	snapshotTypeId   uint32
+	takeSnapshot     func() interface{}
+	restoreSnapshot  func(state interface{})
+	snapshotInterval uint64
+	snapshotFail     func(err error) // 自动保存快照失败的处理函数
+	seq              uint64          // 最后一个事件的序号
+	snapshotSeq      uint64          // 最新快照的序号
+	recovering       bool
+}
+
+// 创建持久化，id在日志中唯一标识服务，journal为nil时使用默认目录的文件日志
+func NewPersistence(id string, journal IJournal) (*Persistence, error) {
+	if journal == nil {
+		j, err := NewFileJournal(JournalDir, true)
+		if err != nil {
+			return nil, err
+		}
+		journal = j
+	}
+	return &Persistence{
+		id:       id,
+		journal:  journal,
+		registry: NewCodecRegistry(nil),
+		applies:  make(map[uint32]func(interface{})),
+	}, nil
+}
+
+// 持久化id
+func (p *Persistence) Id() string {
+	return p.id
+}
+
+// 日志
+func (p *Persistence) Journal() IJournal {
+	return p.journal
+}
+
+// 事件和快照的编解码注册表
+func (p *Persistence) Registry() *CodecRegistry {
+	return p.registry
+}
+
+// 最后一个事件的序号
+func (p *Persistence) Seq() uint64 {
+	return p.seq
+}
+
+// 是否在恢复中，恢复时应用事件不应该产生副作用
+func (p *Persistence) IsRecovering() bool {
+	return p.recovering
+}
+
+// 声明事件，typeId和name写入日志用于解码，apply把事件应用到状态
+func (p *Persistence) RegisterEvent(typeId uint32, name string, prototype interface{}, apply func(event interface{})) error {
+	if err := p.registry.Register(typeId, name, prototype); err != nil {
+		return err
+	}
+	p.applies[typeId] = apply
+	return nil
+}
+
+// 声明快照，take返回当前状态，restore用加载的快照恢复状态
+func (p *Persistence) RegisterSnapshot(typeId uint32, name string, prototype interface{}, take func() interface{}, restore func(state interface{})) error {
+	if err := p.registry.Register(typeId, name, prototype); err != nil {
+		return err
+	}
+	p.snapshotTypeId = typeId
+	p.takeSnapshot = take
+	p.restoreSnapshot = restore
+	return nil
+}
+
+// 每持久化interval个事件自动保存一次快照，0表示不自动保存
+func (p *Persistence) SetSnapshotInterval(interval uint64) {
+	p.snapshotInterval = interval
+}
+
+// 设置自动保存快照失败的处理函数，不设置时输出到全局日志，失败后下一个事件会再次保存
+func (p *Persistence) SetSnapshotFailHandle(handle func(err error)) {
+	p.snapshotFail = handle
+}
+
+// 恢复状态，加载最新的快照后重放之后的事件
+func (p *Persistence) Recover() error {
+	p.recovering = true
+	defer func() {
+		p.recovering = false
+	}()
+	if p.restoreSnapshot != nil {
+		record, o, err := p.journal.LoadSnapshot(p.id)
+		if err != nil {
+			return err
+		}
+		if o {
+			state, err := p.registry.Decode(record.TypeId, record.Data)
+			if err != nil {
+				return err
+			}
+			p.restoreSnapshot(state)
+			p.seq = record.Seq
+			p.snapshotSeq = record.Seq
+		}
+	}
+	return p.journal.Replay(p.id, p.seq+1, func(record JournalRecord) error {
+		event, err := p.registry.Decode(record.TypeId, record.Data)
+		if err != nil {
+			return err
+		}
+		apply, o := p.applies[record.TypeId]
+		if !o {
+			return ErrCodecTypeNotRegistered
+		}
+		apply(event)
+		p.seq = record.Seq
+		return nil
+	})
+}
+
+// 持久化事件，写入日志成功后才应用到状态，返回的错误只表示事件没有写入
+// 事件写入后自动保存快照失败不影响事件，交给SetSnapshotFailHandle设置的处理函数
+func (p *Persistence) Persist(event interface{}) error {
+	typeId, data, err := p.registry.Encode(event)
+	if err != nil {
+		return err
+	}
+	apply, o := p.applies[typeId]
+	if !o {
+		return ErrCodecTypeNotRegistered
+	}
+	record := JournalRecord{Seq: p.seq + 1, TypeId: typeId, Data: data}
+	if err := p.journal.Append(p.id, record); err != nil {
+		return err
+	}
+	p.seq = record.Seq
+	apply(event)
+	if p.snapshotInterval > 0 && p.seq-p.snapshotSeq >= p.snapshotInterval {
+		if err := p.SaveSnapshot(); err != nil {
+			p.onSnapshotFail(err)
+		}
+	}
+	return nil
+}
+
+// 自动保存快照失败
+func (p *Persistence) onSnapshotFail(err error) {
+	if p.snapshotFail != nil {
+		p.snapshotFail(err)
+		return
+	}
+	if l := GetLogger(); l != nil {
+		l.Log(LogLevelError, LogEventSnapshotFailed, LogField{Key: "persistence", Value: p.id}, LogField{Key: "seq", Value: p.seq}, LogField{Key: "error", Value: err})
+	}
+}
+
+// 保存快照
+func (p *Persistence) SaveSnapshot() error {
+	if p.takeSnapshot == nil {
+		return ErrSnapshotNotRegistered
+	}
+	_, data, err := p.registry.Encode(p.takeSnapshot())
+	if err != nil {
+		return err
+	}
+	if err := p.journal.SaveSnapshot(p.id, JournalRecord{Seq: p.seq, TypeId: p.snapshotTypeId, Data: data}); err != nil {
+		return err
+	}
+	p.snapshotSeq = p.seq
+	return nil
+}
+
+// 设置持久化并恢复状态，在Run之前调用
+func (s *LocalService) InitPersistence(p *Persistence) error {
+	s.persistence = p
+	return p.Recover()
+}
+
+// 服务的持久化
+func (s *LocalService) Persistence() *Persistence {
+	return s.persistence
+}
+
+// 持久化事件并应用到状态
+func (s *LocalService) Persist(event interface{}) error {
+	if s.persistence == nil {
+		return ErrPersistenceNotInit
+	}
+	return s.persistence.Persist(event)
+}
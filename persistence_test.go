@@ -0,0 +1,231 @@
+package gproc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type itemAdded struct {
+	ItemId int32
+	Num    int32
+}
+
+type itemRemoved struct {
+	ItemId int32
+}
+
+type shopState struct {
+	Items map[int32]int32
+}
+
+const (
+	eventItemAdded   = 1
+	eventItemRemoved = 2
+	snapshotShop     = 100
+)
+
+// 持久化的商店服务
+type persistentShop struct {
+	*LocalService
+	items map[int32]int32
+}
+
+func newPersistentShop(t *testing.T, journal IJournal, interval uint64) *persistentShop {
+	shop := &persistentShop{LocalService: NewDefaultLocalService(), items: make(map[int32]int32)}
+	p, err := NewPersistence("shop", journal)
+	if err != nil {
+		t.Fatalf("new persistence err: %v", err)
+	}
+	p.RegisterEvent(eventItemAdded, "ItemAdded", itemAdded{}, func(event interface{}) {
+		e := event.(itemAdded)
+		shop.items[e.ItemId] += e.Num
+	})
+	p.RegisterEvent(eventItemRemoved, "ItemRemoved", &itemRemoved{}, func(event interface{}) {
+		delete(shop.items, event.(*itemRemoved).ItemId)
+	})
+	p.RegisterSnapshot(snapshotShop, "ShopState", shopState{}, func() interface{} {
+		return shopState{Items: shop.items}
+	}, func(state interface{}) {
+		shop.items = state.(shopState).Items
+	})
+	p.SetSnapshotInterval(interval)
+	if err := shop.InitPersistence(p); err != nil {
+		t.Fatalf("init persistence err: %v", err)
+	}
+	shop.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+		var err error
+		switch e := args.(type) {
+		case itemAdded, *itemRemoved:
+			err = shop.Persist(e)
+		}
+		sender.Send(MsgIdBuyItem, err)
+	})
+	return shop
+}
+
+func (shop *persistentShop) apply(t *testing.T, events ...interface{}) {
+	go shop.Run()
+	defer shop.Close()
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(shop, "admin")
+	acked := 0
+	r.RegisterCallback(MsgIdBuyItem, func(args interface{}) {
+		if args != nil {
+			t.Errorf("persist err: %v", args)
+		}
+		acked += 1
+	})
+	for _, e := range events {
+		r.Request(MsgIdBuyItem, e)
+	}
+	waitUntil(t, func() bool { return acked == len(events) }, p)
+}
+
+func TestPersistenceFileJournal(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := NewFileJournal(dir, true)
+	if err != nil {
+		t.Fatalf("new file journal err: %v", err)
+	}
+	shop := newPersistentShop(t, journal, 3)
+	shop.apply(t, itemAdded{ItemId: 1, Num: 2}, itemAdded{ItemId: 2, Num: 1}, itemAdded{ItemId: 1, Num: 3}, &itemRemoved{ItemId: 2}, itemAdded{ItemId: 3, Num: 7})
+	journal.Close()
+
+	// 重启后从快照(序号3)和之后的两个事件恢复
+	journal, _ = NewFileJournal(dir, true)
+	defer journal.Close()
+	if record, o, _ := journal.LoadSnapshot("shop"); !o || record.Seq != 3 {
+		t.Fatalf("expect snapshot at seq 3, got %v %v", o, record.Seq)
+	}
+	restarted := newPersistentShop(t, journal, 3)
+	if len(restarted.items) != 2 || restarted.items[1] != 5 || restarted.items[3] != 7 {
+		t.Fatalf("unexpected recovered items %v", restarted.items)
+	}
+	if restarted.Persistence().Seq() != 5 {
+		t.Fatalf("expect seq 5, got %v", restarted.Persistence().Seq())
+	}
+}
+
+// 崩溃留下的不完整记录在恢复时忽略，新事件从截断处继续追加
+func TestFileJournalTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+	journal, _ := NewFileJournal(dir, false)
+	journal.Append("shop", JournalRecord{Seq: 1, TypeId: 1, Data: []byte("a")})
+	journal.Append("shop", JournalRecord{Seq: 2, TypeId: 1, Data: []byte("b")})
+	journal.Close()
+
+	f, _ := os.OpenFile(filepath.Join(dir, "shop.journal"), os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write(encodeJournalRecord(JournalRecord{Seq: 3, TypeId: 1, Data: []byte("partial")})[:10])
+	f.Close()
+
+	journal, _ = NewFileJournal(dir, false)
+	defer journal.Close()
+	if err := journal.Append("shop", JournalRecord{Seq: 3, TypeId: 1, Data: []byte("c")}); err != nil {
+		t.Fatalf("append err: %v", err)
+	}
+	var data []string
+	err := journal.Replay("shop", 2, func(record JournalRecord) error {
+		data = append(data, string(record.Data))
+		return nil
+	})
+	if err != nil || len(data) != 2 || data[0] != "b" || data[1] != "c" {
+		t.Fatalf("unexpected replay %v %v", data, err)
+	}
+}
+
+// 中间损坏的记录不截断，之后完整的记录还在，追加和重放都返回ErrJournalCorrupted
+func TestFileJournalCorruptedMiddle(t *testing.T) {
+	dir := t.TempDir()
+	journal, _ := NewFileJournal(dir, false)
+	for i, data := range []string{"a", "b", "c"} {
+		journal.Append("shop", JournalRecord{Seq: uint64(i + 1), TypeId: 1, Data: []byte(data)})
+	}
+	journal.Close()
+
+	path := filepath.Join(dir, "shop.journal")
+	content, _ := os.ReadFile(path)
+	size := len(content)
+	// 损坏第二条记录的数据
+	content[len(encodeJournalRecord(JournalRecord{Seq: 1, TypeId: 1, Data: []byte("a")}))+20] ^= 0xff
+	os.WriteFile(path, content, 0644)
+
+	journal, _ = NewFileJournal(dir, false)
+	defer journal.Close()
+	if err := journal.Append("shop", JournalRecord{Seq: 4, TypeId: 1, Data: []byte("d")}); err != ErrJournalCorrupted {
+		t.Fatalf("expect ErrJournalCorrupted, got %v", err)
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(size) {
+		t.Fatalf("journal truncated to %v", info.Size())
+	}
+	var data []string
+	err := journal.Replay("shop", 1, func(record JournalRecord) error {
+		data = append(data, string(record.Data))
+		return nil
+	})
+	if err != ErrJournalCorrupted || len(data) != 1 || data[0] != "a" {
+		t.Fatalf("unexpected replay %v %v", data, err)
+	}
+}
+
+// 保存快照失败的日志
+type failSnapshotJournal struct {
+	*MemoryJournal
+}
+
+func (j failSnapshotJournal) SaveSnapshot(id string, record JournalRecord) error {
+	return errors.New("disk full")
+}
+
+// 自动保存快照失败时事件已经写入，Persist不返回错误，失败交给处理函数
+func TestPersistenceSnapshotFail(t *testing.T) {
+	journal := failSnapshotJournal{NewMemoryJournal()}
+	p, _ := NewPersistence("shop", journal)
+	items := make(map[int32]int32)
+	p.RegisterEvent(eventItemAdded, "ItemAdded", itemAdded{}, func(event interface{}) {
+		e := event.(itemAdded)
+		items[e.ItemId] += e.Num
+	})
+	p.RegisterSnapshot(snapshotShop, "ShopState", shopState{}, func() interface{} {
+		return shopState{Items: items}
+	}, func(state interface{}) {})
+	p.SetSnapshotInterval(1)
+	var fails []error
+	p.SetSnapshotFailHandle(func(err error) {
+		fails = append(fails, err)
+	})
+	for i := 0; i < 2; i++ {
+		if err := p.Persist(itemAdded{ItemId: 1, Num: 1}); err != nil {
+			t.Fatalf("persist err: %v", err)
+		}
+	}
+	if items[1] != 2 || p.Seq() != 2 || len(fails) != 2 {
+		t.Fatalf("unexpected state %v %v %v", items, p.Seq(), fails)
+	}
+}
+
+func TestCodecRegistry(t *testing.T) {
+	r := NewCodecRegistry(nil)
+	if err := r.Register(eventItemAdded, "ItemAdded", itemAdded{}); err != nil {
+		t.Fatalf("register err: %v", err)
+	}
+	if err := r.Register(eventItemAdded, "ItemRemoved", &itemRemoved{}); err != ErrCodecIdExists {
+		t.Fatalf("expect ErrCodecIdExists, got %v", err)
+	}
+	if _, _, err := r.Encode(&itemRemoved{}); err != ErrCodecTypeNotRegistered {
+		t.Fatalf("expect ErrCodecTypeNotRegistered, got %v", err)
+	}
+	id, data, err := r.Encode(itemAdded{ItemId: 9, Num: 1})
+	if err != nil || id != eventItemAdded {
+		t.Fatalf("encode %v %v", id, err)
+	}
+	v, err := r.Decode(id, data)
+	if err != nil || v != (itemAdded{ItemId: 9, Num: 1}) {
+		t.Fatalf("decode %v %v", v, err)
+	}
+	if name, _ := r.Name(id); name != "ItemAdded" {
+		t.Fatalf("unexpected name %v", name)
+	}
+}
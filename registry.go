@@ -0,0 +1,302 @@
+package gproc
+
+import (
+	"sync"
+)
+
+const (
+	LocalNodeName = "local" // 本地注册表的节点名
+)
+
+// 注册表事件类型
+type RegistryEventType uint8
+
+const (
+	RegistryEventRegister   RegistryEventType = 1 // 名字被注册
+	RegistryEventMove       RegistryEventType = 2 // 名字转移到了其他节点或其他处理器
+	RegistryEventUnregister RegistryEventType = 3 // 名字被删除或所在节点离开
+)
+
+// 注册表事件
+type RegistryEvent struct {
+	Type    RegistryEventType
+	Name    string
+	Node    string          // 名字所在的节点
+	Handler IRequestHandler // 名字对应的处理器，Unregister事件时为nil
+}
+
+// 服务注册表接口
+type IRegistry interface {
+	// 注册服务名
+	Register(name string, handler IRequestHandler) error
+	// 注销服务名
+	Unregister(name string) error
+	// 查找服务名对应的处理器
+	Lookup(name string) (IRequestHandler, error)
+	// 监听服务名的变化，返回取消监听的函数
+	Watch(name string, watcher func(event *RegistryEvent)) func()
+}
+
+// 注册项
+type registryEntry struct {
+	node    string
+	handler IRequestHandler
+}
+
+// 注册表数据，可被多个节点共享
+type registryStore struct {
+	locker    sync.RWMutex
+	entries   map[string]*registryEntry
+	watchers  map[string]map[uint64]func(*RegistryEvent)
+	watcherId uint64
+}
+
+// 创建注册表数据
+func newRegistryStore() *registryStore {
+	return &registryStore{
+		entries:  make(map[string]*registryEntry),
+		watchers: make(map[string]map[uint64]func(*RegistryEvent)),
+	}
+}
+
+// 注册
+func (s *registryStore) register(node string, name string, handler IRequestHandler) error {
+	if handler == nil {
+		return ErrRegistryNilHandler
+	}
+	s.locker.Lock()
+	typ := RegistryEventRegister
+	if e, o := s.entries[name]; o {
+		if e.node != node {
+			s.locker.Unlock()
+			return ErrRegistryNameExists
+		}
+		if e.handler == handler {
+			s.locker.Unlock()
+			return nil
+		}
+		typ = RegistryEventMove
+	}
+	s.entries[name] = &registryEntry{node: node, handler: handler}
+	watchers := s.copyWatchers(name)
+	s.locker.Unlock()
+	notifyWatchers(watchers, &RegistryEvent{Type: typ, Name: name, Node: node, Handler: handler})
+	return nil
+}
+
+// 转移，名字可以从其他节点转移过来
+func (s *registryStore) move(node string, name string, handler IRequestHandler) error {
+	if handler == nil {
+		return ErrRegistryNilHandler
+	}
+	s.locker.Lock()
+	e, o := s.entries[name]
+	if !o {
+		s.locker.Unlock()
+		return ErrRegistryNameNotFound
+	}
+	if e.node == node && e.handler == handler {
+		s.locker.Unlock()
+		return nil
+	}
+	s.entries[name] = &registryEntry{node: node, handler: handler}
+	watchers := s.copyWatchers(name)
+	s.locker.Unlock()
+	notifyWatchers(watchers, &RegistryEvent{Type: RegistryEventMove, Name: name, Node: node, Handler: handler})
+	return nil
+}
+
+// 注销
+func (s *registryStore) unregister(node string, name string) error {
+	s.locker.Lock()
+	e, o := s.entries[name]
+	if !o || e.node != node {
+		s.locker.Unlock()
+		return ErrRegistryNameNotFound
+	}
+	delete(s.entries, name)
+	watchers := s.copyWatchers(name)
+	s.locker.Unlock()
+	notifyWatchers(watchers, &RegistryEvent{Type: RegistryEventUnregister, Name: name, Node: node})
+	return nil
+}
+
+// 注销节点上的所有名字
+func (s *registryStore) unregisterNode(node string) {
+	var events []*RegistryEvent
+	var watcherList [][]func(*RegistryEvent)
+	s.locker.Lock()
+	for name, e := range s.entries {
+		if e.node != node {
+			continue
+		}
+		delete(s.entries, name)
+		events = append(events, &RegistryEvent{Type: RegistryEventUnregister, Name: name, Node: node})
+		watcherList = append(watcherList, s.copyWatchers(name))
+	}
+	s.locker.Unlock()
+	for i, event := range events {
+		notifyWatchers(watcherList[i], event)
+	}
+}
+
+// 查找
+func (s *registryStore) lookup(name string) (*registryEntry, error) {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+	e, o := s.entries[name]
+	if !o {
+		return nil, ErrRegistryNameNotFound
+	}
+	return e, nil
+}
+
+// 监听
+func (s *registryStore) watch(name string, watcher func(*RegistryEvent)) func() {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.watcherId += 1
+	id := s.watcherId
+	ws, o := s.watchers[name]
+	if !o {
+		ws = make(map[uint64]func(*RegistryEvent))
+		s.watchers[name] = ws
+	}
+	ws[id] = watcher
+	return func() {
+		s.locker.Lock()
+		defer s.locker.Unlock()
+		ws, o := s.watchers[name]
+		if !o {
+			return
+		}
+		delete(ws, id)
+		if len(ws) == 0 {
+			delete(s.watchers, name)
+		}
+	}
+}
+
+// 复制监听者，在锁外调用监听函数，避免监听函数里再访问注册表造成死锁
+func (s *registryStore) copyWatchers(name string) []func(*RegistryEvent) {
+	ws := s.watchers[name]
+	if len(ws) == 0 {
+		return nil
+	}
+	watchers := make([]func(*RegistryEvent), 0, len(ws))
+	for _, w := range ws {
+		watchers = append(watchers, w)
+	}
+	return watchers
+}
+
+// 通知监听者
+func notifyWatchers(watchers []func(*RegistryEvent), event *RegistryEvent) {
+	for _, w := range watchers {
+		w(event)
+	}
+}
+
+// 注册表，绑定到某个节点，同一个registryStore上的注册表互相可见
+// 监听函数在修改注册表的goroutine中同步调用，一般应把事件发回自己的goroutine再处理
+type Registry struct {
+	store *registryStore
+	node  string
+}
+
+// 创建进程内的注册表
+func NewLocalRegistry() *Registry {
+	return &Registry{store: newRegistryStore(), node: LocalNodeName}
+}
+
+// 节点名
+func (r *Registry) Node() string {
+	return r.node
+}
+
+// 注册服务名，名字已被其他节点注册时返回ErrRegistryNameExists，同一节点重复注册则替换处理器
+func (r *Registry) Register(name string, handler IRequestHandler) error {
+	return r.store.register(r.node, name, handler)
+}
+
+// 把其他节点上的服务名转移到本节点
+func (r *Registry) MoveTo(name string, handler IRequestHandler) error {
+	return r.store.move(r.node, name, handler)
+}
+
+// 注销本节点注册的服务名
+func (r *Registry) Unregister(name string) error {
+	return r.store.unregister(r.node, name)
+}
+
+// 查找服务名对应的处理器
+func (r *Registry) Lookup(name string) (IRequestHandler, error) {
+	e, err := r.store.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return e.handler, nil
+}
+
+// 查找服务名所在的节点
+func (r *Registry) LookupNode(name string) (string, error) {
+	e, err := r.store.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return e.node, nil
+}
+
+// 监听服务名的变化
+func (r *Registry) Watch(name string, watcher func(event *RegistryEvent)) func() {
+	return r.store.watch(name, watcher)
+}
+
+// 回环集群，多个节点在同一进程中共享注册数据，用于多节点测试
+type LoopbackCluster struct {
+	store  *registryStore
+	locker sync.Mutex
+	nodes  map[string]*Registry
+}
+
+// 创建回环集群
+func NewLoopbackCluster() *LoopbackCluster {
+	return &LoopbackCluster{
+		store: newRegistryStore(),
+		nodes: make(map[string]*Registry),
+	}
+}
+
+// 加入节点，返回该节点的注册表
+func (c *LoopbackCluster) Join(node string) *Registry {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	r, o := c.nodes[node]
+	if !o {
+		r = &Registry{store: c.store, node: node}
+		c.nodes[node] = r
+	}
+	return r
+}
+
+// 节点离开，该节点注册的名字都会被注销
+func (c *LoopbackCluster) Leave(node string) {
+	c.locker.Lock()
+	_, o := c.nodes[node]
+	delete(c.nodes, node)
+	c.locker.Unlock()
+	if o {
+		c.store.unregisterNode(node)
+	}
+}
+
+// 节点列表
+func (c *LoopbackCluster) Nodes() []string {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	nodes := make([]string, 0, len(c.nodes))
+	for n := range c.nodes {
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
@@ -0,0 +1,86 @@
+package gproc
+
+import (
+	"testing"
+)
+
+func TestLocalRegistry(t *testing.T) {
+	registry := NewLocalRegistry()
+	shop := NewShopHandler()
+	shop.Init()
+
+	var events []*RegistryEvent
+	cancel := registry.Watch("shop", func(event *RegistryEvent) {
+		events = append(events, event)
+	})
+
+	if _, err := registry.Lookup("shop"); err != ErrRegistryNameNotFound {
+		t.Fatalf("lookup before register expect ErrRegistryNameNotFound, got %v", err)
+	}
+	if err := registry.Register("shop", shop); err != nil {
+		t.Fatalf("register shop err: %v", err)
+	}
+	h, err := registry.Lookup("shop")
+	if err != nil || h != IRequestHandler(shop) {
+		t.Fatalf("lookup shop got %v, err %v", h, err)
+	}
+
+	shop2 := NewShopHandler()
+	shop2.Init()
+	if err := registry.Register("shop", shop2); err != nil {
+		t.Fatalf("re-register shop err: %v", err)
+	}
+	if err := registry.Unregister("shop"); err != nil {
+		t.Fatalf("unregister shop err: %v", err)
+	}
+	cancel()
+	registry.Register("shop", shop)
+
+	expect := []RegistryEventType{RegistryEventRegister, RegistryEventMove, RegistryEventUnregister}
+	if len(events) != len(expect) {
+		t.Fatalf("expect %v events, got %v", len(expect), len(events))
+	}
+	for i, typ := range expect {
+		if events[i].Type != typ {
+			t.Errorf("event %v expect type %v, got %v", i, typ, events[i].Type)
+		}
+	}
+}
+
+func TestLoopbackClusterRegistry(t *testing.T) {
+	cluster := NewLoopbackCluster()
+	node1 := cluster.Join("node1")
+	node2 := cluster.Join("node2")
+
+	shop := NewShopHandler()
+	shop.Init()
+	if err := node1.Register("shop", shop); err != nil {
+		t.Fatalf("node1 register shop err: %v", err)
+	}
+	if err := node2.Register("shop", shop); err != ErrRegistryNameExists {
+		t.Fatalf("node2 register shop expect ErrRegistryNameExists, got %v", err)
+	}
+	if h, err := node2.Lookup("shop"); err != nil || h != IRequestHandler(shop) {
+		t.Fatalf("node2 lookup shop got %v, err %v", h, err)
+	}
+
+	var last *RegistryEvent
+	node1.Watch("shop", func(event *RegistryEvent) {
+		last = event
+	})
+
+	if err := node2.MoveTo("shop", shop); err != nil {
+		t.Fatalf("move shop to node2 err: %v", err)
+	}
+	if last == nil || last.Type != RegistryEventMove || last.Node != "node2" {
+		t.Fatalf("expect move event to node2, got %+v", last)
+	}
+
+	cluster.Leave("node2")
+	if last.Type != RegistryEventUnregister {
+		t.Fatalf("expect unregister event after node2 leave, got %+v", last)
+	}
+	if _, err := node1.Lookup("shop"); err != ErrRegistryNameNotFound {
+		t.Fatalf("lookup after node leave expect ErrRegistryNameNotFound, got %v", err)
+	}
+}
@@ -1,5 +1,9 @@
 package gproc
 
+import (
+	"time"
+)
+
 // 请求者，发起请求到IRequesterHandler，除创建初始化外整个生命周期在同一个goroutine中
 // 一般跟IRequestHandler不在同一个goroutine
 type Requester struct {
@@ -9,6 +13,7 @@ type Requester struct {
 	forwardMap  map[uint32]func(fromKey interface{}, args interface{}) // 转发消息到处理函数的映射
 	options     RequestOptions                                         // 请求选项
 	key         interface{}                                            // requester的key，告诉对面的receiver唯一标识自己，用于转发和通知
+	pending     map[uint64]*pendingRequest                             // 设置了超时或重试时等待返回的请求
 }
 
 // 创建请求者
@@ -22,27 +27,127 @@ func NewRequester(owner IResponseHandler, receiver IRequestHandler, key interfac
 		key:         key,
 		callbackMap: make(map[uint32]func(interface{})),
 		forwardMap:  make(map[uint32]func(interface{}, interface{})),
+		pending:     make(map[uint64]*pendingRequest),
 	}
 	owner.addRequester(req)
 	for _, option := range options {
-		option(req.options)
+		option(&req.options)
 	}
 	req.signUp()
 	return req
 }
 
-// 请求
-func (r *Requester) Request(msgId uint32, args interface{}) error {
+// 请求，设置了超时或重试时，返回会和请求匹配，可重试的错误稍后在持有者的goroutine中重试
+// 请求的消息头默认带到返回中
+func (r *Requester) Request(msgId uint32, args interface{}, options ...SendOption) error {
+	headers := sendHeaders(nil, options)
+	if r.options.requestTimeout > 0 || r.options.retryPolicy != nil {
+		return r.requestPending(msgId, args, headers)
+	}
+
 	var m *msg = getMsg()
 	m.typ = msgNormal
 	m.sender = r.owner
+	m.fromKey = r.key
 	m.id = msgId
 	m.args = args
+	m.headers = headers
+	m.span = startSendSpan(r.owner.currentSpan(), SpanKindRequest, r.key, msgId)
+	if m.span.IsValid() || len(headers) > 0 {
+		// 返回需要带上处理的span和请求的消息头
+		m.sender = &requestSender{owner: r.owner, key: r.key}
+	}
 
 	// 相当于RequestHandler接收消息
 	return r.receiver.recv(m)
 }
 
+// 发起需要等待返回的请求
+func (r *Requester) requestPending(msgId uint32, args interface{}, headers Headers) error {
+	p := &pendingRequest{
+		reqId:    r.owner.nextRequestId(),
+		msgId:    msgId,
+		args:     args,
+		receiver: r.receiver,
+		tracked:  r.options.requestTimeout > 0,
+		headers:  headers,
+	}
+	r.pending[p.reqId] = p
+	err := r.attempt(p)
+	if err != nil && !r.retryLater(p, err) {
+		delete(r.pending, p.reqId)
+		return err
+	}
+	return nil
+}
+
+// 发送一次请求
+func (r *Requester) attempt(p *pendingRequest) error {
+	p.attempt += 1
+	// 有重试策略时通道满不等待，稍后重试
+	err := r.sendPending(p, r.options.retryPolicy != nil)
+	if err == nil && r.options.requestTimeout > 0 {
+		p.timer = r.owner.AfterFunc(time.Duration(r.options.requestTimeout)*time.Millisecond, func() {
+			p.timer = nil
+			p.report(ErrRequestTimeout)
+			r.onFail(p, ErrRequestTimeout)
+		})
+	}
+	return err
+}
+
+// 把等待返回的请求发送到它的接收者
+func (r *Requester) sendPending(p *pendingRequest, noWait bool) error {
+	sender := &requestSender{owner: r.owner, key: r.key, reqId: p.reqId}
+	if p.stream != nil {
+		sender.window = p.stream.window
+	}
+	m := getMsg()
+	m.typ = msgNormal
+	if p.key != nil {
+		m.typ = msgKeyRequest
+		m.toKey = p.key
+	}
+	m.sender = sender
+	m.fromKey = r.key
+	m.id = p.msgId
+	m.args = p.args
+	m.reqId = p.reqId
+	m.noWait = noWait
+	m.tracked = p.tracked
+	m.headers = p.headers
+	m.span = startSendSpan(r.owner.currentSpan(), SpanKindRequest, r.key, p.msgId)
+	return p.receiver.recv(m)
+}
+
+// 按重试策略稍后重试，返回是否会重试
+func (r *Requester) retryLater(p *pendingRequest, err error) bool {
+	policy := r.options.retryPolicy
+	if policy == nil || p.attempt >= policy.maxAttempts() || !policy.retryable(err) {
+		return false
+	}
+	r.owner.AfterFunc(policy.backoff(p.attempt), func() {
+		if _, o := r.pending[p.reqId]; !o {
+			return
+		}
+		if err := r.attempt(p); err != nil {
+			r.onFail(p, err)
+		}
+	})
+	return true
+}
+
+// 请求失败，不能重试时结束请求
+func (r *Requester) onFail(p *pendingRequest, err error) {
+	if r.retryLater(p, err) {
+		return
+	}
+	delete(r.pending, p.reqId)
+	if r.options.failHandle != nil {
+		r.options.failHandle(p.msgId, p.args, err)
+	}
+}
+
 // 注册回调
 func (r *Requester) RegisterCallback(msgId uint32, callback func(interface{})) {
 	r.callbackMap[msgId] = callback
@@ -60,13 +165,15 @@ func (r *Requester) RequestWithCallback(msgId uint32, arg interface{}, callback
 }
 
 // 转发请求
-func (r *Requester) RequestForward(toKey interface{}, msgId uint32, args interface{}) error {
+func (r *Requester) RequestForward(toKey interface{}, msgId uint32, args interface{}, options ...SendOption) error {
 	m := getMsg()
 	m.typ = msgForward
 	m.fromKey = r.key
 	m.toKey = toKey
 	m.id = msgId
 	m.args = args
+	m.headers = sendHeaders(nil, options)
+	m.span = startSendSpan(r.owner.currentSpan(), SpanKindForward, r.key, msgId)
 	return r.receiver.recv(m)
 }
 
@@ -77,13 +184,56 @@ func (r *Requester) RegisterForward(msgId uint32, handle func(interface{}, inter
 
 // 处理回调
 func (r *Requester) handle(m *msg) bool {
-	if m.typ == msgNormal {
+	if m.typ == msgReply {
+		// 带请求序号的返回只由发起请求的requester处理
+		if m.reqId != 0 {
+			p, o := r.pending[m.reqId]
+			if !o {
+				return false
+			}
+			if p.timer != nil {
+				p.timer.Stop()
+			}
+			if p.tracked {
+				p.report(nil)
+			}
+			delete(r.pending, m.reqId)
+			if p.onReply != nil {
+				p.onReply(m.id, m.args)
+				return true
+			}
+		}
 		callback, o := r.callbackMap[m.id]
 		if !o {
-			return false
+			return m.reqId != 0
 		}
 		callback(m.args)
-	} else if m.typ == msgForward {
+	} else if m.typ == msgReplyError {
+		p, o := r.pending[m.reqId]
+		if !o {
+			return false
+		}
+		if p.timer != nil {
+			p.timer.Stop()
+			p.timer = nil
+		}
+		err := m.args.(error)
+		if p.tracked {
+			p.report(err)
+		}
+		if p.onError != nil {
+			delete(r.pending, m.reqId)
+			p.onError(err)
+		} else {
+			r.onFail(p, err)
+		}
+	} else if m.typ == msgStreamChunk || m.typ == msgStreamEnd {
+		p, o := r.pending[m.reqId]
+		if !o || p.stream == nil {
+			return false
+		}
+		r.handleStream(p, m)
+	} else if m.typ == msgForwarded {
 		handle, o := r.forwardMap[m.id]
 		if !o {
 			return false
@@ -0,0 +1,147 @@
+package gproc
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	RetryMaxAttempts    = 3                                    // 默认最大尝试次数
+	RetryInitialBackoff = time.Duration(10 * time.Millisecond) // 默认第一次重试的等待时间
+	RetryMaxBackoff     = time.Duration(time.Second)           // 默认最大等待时间
+	RetryMultiplier     = 2.0                                  // 默认等待时间的增长倍数
+)
+
+// 重试策略，重试在requester持有者的goroutine中通过定时器执行，重试时使用相同的幂等key
+type RetryPolicy struct {
+	MaxAttempts    int                  // 最大尝试次数，包括第一次
+	InitialBackoff time.Duration        // 第一次重试前的等待时间
+	MaxBackoff     time.Duration        // 最大等待时间
+	Multiplier     float64              // 每次重试等待时间的增长倍数
+	Jitter         float64              // 等待时间的随机抖动比例，0到1之间
+	Retryable      func(err error) bool // 错误是否可以重试，为nil时使用IsRetryableError
+}
+
+// 最大尝试次数
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts <= 0 {
+		return RetryMaxAttempts
+	}
+	return p.MaxAttempts
+}
+
+// 错误是否可以重试
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryableError(err)
+}
+
+// 第attempt次尝试失败后的等待时间
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	initial, max, multiplier := p.InitialBackoff, p.MaxBackoff, p.Multiplier
+	if initial <= 0 {
+		initial = RetryInitialBackoff
+	}
+	if max <= 0 {
+		max = RetryMaxBackoff
+	}
+	if multiplier < 1 {
+		multiplier = RetryMultiplier
+	}
+	d := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(rand.Float64()*2-1)
+	}
+	// 加上抖动后再限制，不超过最大等待时间
+	if d > float64(max) {
+		d = float64(max)
+	}
+	return time.Duration(d)
+}
+
+// 默认可以重试的错误：通道满和请求超时
+func IsRetryableError(err error) bool {
+	return err == ErrMailboxFull || err == ErrRequestTimeout
+}
+
+// 幂等key，同一个请求的多次重试使用相同的key，处理函数可以据此去重
+type IdempotencyKey struct {
+	RequesterKey interface{} // 请求者的key
+	Seq          uint64      // 请求序号，在持有者内唯一
+}
+
+// 获取请求的幂等key，只有设置了超时或重试的请求才有
+func GetIdempotencyKey(sender ISender) (IdempotencyKey, bool) {
+	s, o := sender.(*requestSender)
+	if !o || s.reqId == 0 {
+		return IdempotencyKey{}, false
+	}
+	return IdempotencyKey{RequesterKey: s.key, Seq: s.reqId}, true
+}
+
+// 单个请求的发送者，返回时带上请求序号，用于匹配等待中的请求
+type requestSender struct {
+	owner   IResponseHandler
+	key     interface{}
+	reqId   uint64
+	window  int             // 流式请求的初始额度，0表示不是流式请求
+	handler *RequestHandler // 处理请求的处理器
+	stream  *Stream         // 处理函数打开的流
+	span    SpanContext     // 处理请求的span，返回时带上
+	headers Headers         // 请求的消息头，返回时带上
+}
+
+// 返回
+func (s *requestSender) Send(msgId uint32, args interface{}, options ...SendOption) error {
+	m := getMsg()
+	m.typ = msgReply
+	m.toKey = s.key
+	m.id = msgId
+	m.args = args
+	m.reqId = s.reqId
+	m.span = s.span
+	m.headers = sendHeaders(s.headers, options)
+	return s.owner.send(m)
+}
+
+// 发送构造好的消息
+func (s *requestSender) send(m *msg) error {
+	return s.owner.send(m)
+}
+
+// 请求没能交给处理者，把错误返回给发起者
+func (s *requestSender) fail(err error) error {
+	m := getMsg()
+	m.typ = msgReplyError
+	m.toKey = s.key
+	m.args = err
+	m.reqId = s.reqId
+	m.span = s.span
+	return s.owner.send(m)
+}
+
+// 等待返回的请求
+type pendingRequest struct {
+	reqId    uint64
+	msgId    uint32
+	args     interface{}
+	receiver IRequestHandler                      // 请求的接收者
+	tracked  bool                                 // 结果是否报告给熔断器
+	attempt  int                                  // 已尝试次数
+	timer    *Timer                               // 超时定时器
+	onReply  func(msgId uint32, args interface{}) // 不为nil时返回交给它处理，不再查找回调
+	onError  func(err error)                      // 不为nil时错误返回交给它处理，不再按失败处理
+	stream   *streamCall                          // 流式请求
+	headers  Headers                              // 消息头
+	key      interface{}                          // 按key请求的目标key，由接收者交给key的持有者
+}
+
+// 向熔断器报告请求的结果
+func (p *pendingRequest) report(err error) {
+	if reporter, o := p.receiver.(circuitReporter); o {
+		reporter.report(err)
+	}
+}
@@ -0,0 +1,121 @@
+package gproc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := &RetryPolicy{InitialBackoff: time.Millisecond * 10, MaxBackoff: time.Millisecond * 50, Multiplier: 2}
+	expect := []time.Duration{10, 20, 40, 50, 50}
+	for i, d := range expect {
+		if b := policy.backoff(i + 1); b != d*time.Millisecond {
+			t.Errorf("attempt %v expect backoff %v, got %v", i+1, d*time.Millisecond, b)
+		}
+	}
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if b := policy.backoff(1); b < time.Millisecond*5 || b > time.Millisecond*15 {
+			t.Fatalf("backoff with jitter out of range: %v", b)
+		}
+		// 限制的尝试加上抖动也不超过最大等待时间
+		for attempt := 4; attempt <= 5; attempt++ {
+			if b := policy.backoff(attempt); b < time.Millisecond*25 || b > time.Millisecond*50 {
+				t.Fatalf("attempt %v clamped backoff with jitter out of range: %v", attempt, b)
+			}
+		}
+	}
+}
+
+func TestRequestRetryOnTimeout(t *testing.T) {
+	service := NewDefaultRequestHandler()
+	var keys []IdempotencyKey
+	service.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+		key, o := GetIdempotencyKey(sender)
+		if !o {
+			t.Errorf("request has no idempotency key")
+			return
+		}
+		keys = append(keys, key)
+		// 前两次不返回，让请求超时重试
+		if len(keys) >= 3 {
+			sender.Send(MsgIdBuyItem, len(keys))
+		}
+	})
+	go service.Run()
+	defer service.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(service, int32(1), RequestTimeout(20), RequestRetry(&RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+	}))
+	var resp interface{}
+	r.RegisterCallback(MsgIdBuyItem, func(args interface{}) {
+		resp = args
+	})
+	if err := r.Request(MsgIdBuyItem, nil); err != nil {
+		t.Fatalf("request err: %v", err)
+	}
+	waitUntil(t, func() bool { return resp != nil }, p)
+	if resp != 3 {
+		t.Fatalf("expect response after 3 attempts, got %v", resp)
+	}
+	if keys[0] != keys[1] || keys[1] != keys[2] || keys[0].RequesterKey != int32(1) {
+		t.Fatalf("retries expect same idempotency key, got %v", keys)
+	}
+}
+
+func TestRequestRetryMailboxFull(t *testing.T) {
+	service := NewRequestHandler(newHandler(1))
+	service.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+		sender.Send(MsgIdBuyItem, args)
+	})
+	defer service.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	// 报名消息占满通道
+	r := p.CreateRequester(service, int32(1), RequestRetry(&RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: time.Millisecond * 5,
+	}))
+	var resp interface{}
+	r.RegisterCallback(MsgIdBuyItem, func(args interface{}) {
+		resp = args
+	})
+	if err := r.Request(MsgIdBuyItem, "item"); err != nil {
+		t.Fatalf("request with full mailbox should retry later, got err %v", err)
+	}
+	go service.Run()
+	waitUntil(t, func() bool { return resp != nil }, p)
+	if resp != "item" {
+		t.Fatalf("unexpected response %v", resp)
+	}
+}
+
+func TestRequestRetryGiveUp(t *testing.T) {
+	service := NewDefaultRequestHandler()
+	attempts := 0
+	service.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+		attempts += 1
+	})
+	go service.Run()
+	defer service.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	var failErr error
+	r := p.CreateRequester(service, int32(1),
+		RequestTimeout(10),
+		RequestRetry(&RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}),
+		RequestFailHandle(func(msgId uint32, args interface{}, err error) {
+			failErr = err
+		}))
+	r.Request(MsgIdBuyItem, nil)
+	waitUntil(t, func() bool { return failErr != nil }, p)
+	if failErr != ErrRequestTimeout {
+		t.Fatalf("expect ErrRequestTimeout, got %v", failErr)
+	}
+}
@@ -0,0 +1,303 @@
+package gproc
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// 路由策略
+type RouterStrategy uint8
+
+const (
+	RouterRoundRobin     RouterStrategy = 0 // 轮询
+	RouterLeastMailbox   RouterStrategy = 1 // 邮箱中待处理消息最少
+	RouterRandom         RouterStrategy = 2 // 随机
+	RouterConsistentHash RouterStrategy = 3 // 按requester的key一致性哈希
+)
+
+// 路由器，前端是一个IRequestHandler，后面是N个相同的LocalService，每个LocalService在自己的goroutine中处理请求
+// 报名消息会复制到所有worker，所以任何一个worker都能回复、通知和转发到已报名的requester
+type Router struct {
+	locker          sync.RWMutex
+	strategy        RouterStrategy
+	factory         func() *LocalService
+	workers         []*LocalService
+	ring            *hashRing
+	counter         uint64
+	signUpMap       map[interface{}]ISender // 已报名的requester，新加入的worker要补上
+	handleMap       map[uint32]func(ISender, interface{})
+	noTargetHandles map[uint32]func(ISender, interface{}, interface{})
+	running         bool
+	closed          bool
+	chClose         chan struct{}
+}
+
+// 创建路由器，factory用来创建worker，为nil时创建默认的LocalService
+func NewRouter(strategy RouterStrategy, size int, factory func() *LocalService) *Router {
+	r := &Router{}
+	r.Init(strategy, size, factory)
+	return r
+}
+
+// 初始化
+func (r *Router) Init(strategy RouterStrategy, size int, factory func() *LocalService) {
+	if factory == nil {
+		factory = NewDefaultLocalService
+	}
+	r.strategy = strategy
+	r.factory = factory
+	r.ring = newHashRing(0)
+	r.signUpMap = make(map[interface{}]ISender)
+	r.handleMap = make(map[uint32]func(ISender, interface{}))
+	r.noTargetHandles = make(map[uint32]func(ISender, interface{}, interface{}))
+	r.chClose = make(chan struct{})
+	r.Resize(size)
+}
+
+// worker数量
+func (r *Router) Size() int {
+	r.locker.RLock()
+	defer r.locker.RUnlock()
+	return len(r.workers)
+}
+
+// 获取worker
+func (r *Router) Worker(idx int) *LocalService {
+	r.locker.RLock()
+	defer r.locker.RUnlock()
+	if idx < 0 || idx >= len(r.workers) {
+		return nil
+	}
+	return r.workers[idx]
+}
+
+// 调整worker数量，运行时也可调用
+// 被移除的worker不再接收路由的消息，运行中的处理完邮箱中已有的消息后关闭，没有运行的把邮箱中的消息交给剩下的worker，放不下的作为死信
+func (r *Router) Resize(size int) {
+	if size <= 0 {
+		size = 1
+	}
+	r.locker.Lock()
+	if r.closed {
+		r.locker.Unlock()
+		return
+	}
+	for len(r.workers) < size {
+		r.addWorker()
+	}
+	var removed []*LocalService
+	for len(r.workers) > size {
+		idx := len(r.workers) - 1
+		removed = append(removed, r.workers[idx])
+		r.workers = r.workers[:idx]
+		r.ring.remove(routerWorkerName(idx))
+	}
+	running := r.running
+	r.locker.Unlock()
+	for _, w := range removed {
+		r.retireWorker(w, running)
+	}
+}
+
+// 关闭被移除的worker，不丢弃邮箱中的消息，不能加锁调用
+func (r *Router) retireWorker(w *LocalService, running bool) {
+	if running {
+		// 关闭排在已有的消息之后
+		m := getMsg()
+		m.typ = msgExec
+		m.args = func() {
+			w.Close()
+		}
+		if w.recv(m) != nil {
+			w.Close()
+		}
+		return
+	}
+	// 关闭时还在邮箱中的消息会作为死信，先不等待地交给剩下的worker
+	for {
+		select {
+		case m := <-w.handler.ch:
+			// 剩下的worker都有报名
+			if m.typ == msgSignup {
+				putMsg(m)
+				continue
+			}
+			r.reroute(m)
+		default:
+			w.Close()
+			return
+		}
+	}
+}
+
+// 把被移除的worker邮箱中的消息交给剩下的worker，不阻塞调整大小，剩下的worker邮箱满时作为死信
+func (r *Router) reroute(m *msg) {
+	r.locker.RLock()
+	if r.closed {
+		r.locker.RUnlock()
+		publishDeadLetter(DeadLetterClosed, m, ErrClosed)
+		putMsg(m)
+		return
+	}
+	w := r.route(m)
+	r.locker.RUnlock()
+	if err := w.handler.offer(m); err != nil {
+		publishDeadLetter(DeadLetterWorkerRetired, m, err)
+		putMsg(m)
+	}
+}
+
+// 添加worker，调用者加锁
+func (r *Router) addWorker() {
+	w := r.factory()
+	for id, handle := range r.handleMap {
+		w.RegisterHandle(id, handle)
+	}
+	for id, handle := range r.noTargetHandles {
+		w.RegisterForward4NoTarget(id, handle)
+	}
+	for key, sender := range r.signUpMap {
+		m := getMsg()
+		m.typ = msgSignup
+		m.fromKey = key
+		m.sender = sender
+		// worker还没有运行，直接处理报名，不经过邮箱
+		w.processMsg(m)
+	}
+	r.ring.add(routerWorkerName(len(r.workers)))
+	r.workers = append(r.workers, w)
+	if r.running {
+		go w.Run()
+	}
+}
+
+// 注册请求处理器，所有worker共用，已存在和以后加入的worker都会注册
+func (r *Router) RegisterHandle(msgId uint32, handle func(ISender, interface{})) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.handleMap[msgId] = handle
+	for _, w := range r.workers {
+		w.RegisterHandle(msgId, handle)
+	}
+}
+
+// 注册无法找到目标的转发处理器
+func (r *Router) RegisterForward4NoTarget(msgId uint32, handle func(ISender, interface{}, interface{})) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.noTargetHandles[msgId] = handle
+	for _, w := range r.workers {
+		w.RegisterForward4NoTarget(msgId, handle)
+	}
+}
+
+// 运行所有worker，阻塞到路由器关闭
+func (r *Router) Run() error {
+	r.locker.Lock()
+	if r.closed {
+		r.locker.Unlock()
+		return ErrClosed
+	}
+	r.running = true
+	for _, w := range r.workers {
+		go w.Run()
+	}
+	r.locker.Unlock()
+	<-r.chClose
+	return nil
+}
+
+// 关闭路由器和所有worker
+func (r *Router) Close() {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	for _, w := range r.workers {
+		w.Close()
+	}
+	close(r.chClose)
+}
+
+// 接收消息，按策略路由到某个worker
+func (r *Router) recv(m *msg) error {
+	if m.typ == msgSignup {
+		return r.signUp(m)
+	}
+	r.locker.RLock()
+	if r.closed {
+		r.locker.RUnlock()
+		publishDeadLetter(DeadLetterClosed, m, ErrClosed)
+		return ErrClosed
+	}
+	w := r.route(m)
+	r.locker.RUnlock()
+	return w.recv(m)
+}
+
+// 报名，复制到所有worker，在锁外发送，一个worker的邮箱满了不影响其他消息的路由
+func (r *Router) signUp(m *msg) error {
+	r.locker.Lock()
+	if r.closed {
+		r.locker.Unlock()
+		publishDeadLetter(DeadLetterClosed, m, ErrClosed)
+		return ErrClosed
+	}
+	r.signUpMap[m.fromKey] = m.sender
+	workers := append([]*LocalService(nil), r.workers...)
+	r.locker.Unlock()
+	var err error
+	for _, w := range workers {
+		c := getMsg()
+		c.typ = msgSignup
+		c.fromKey = m.fromKey
+		c.sender = m.sender
+		// 发送时已被移除的worker不需要报名
+		if e := w.recv(c); e != nil && e != ErrClosed && err == nil {
+			err = e
+		}
+	}
+	putMsg(m)
+	return err
+}
+
+// 选择worker，调用者加读锁
+func (r *Router) route(m *msg) *LocalService {
+	n := len(r.workers)
+	switch r.strategy {
+	case RouterLeastMailbox:
+		idx := 0
+		for i := 1; i < n; i++ {
+			if len(r.workers[i].handler.ch) < len(r.workers[idx].handler.ch) {
+				idx = i
+			}
+		}
+		return r.workers[idx]
+	case RouterRandom:
+		return r.workers[rand.Intn(n)]
+	case RouterConsistentHash:
+		key := m.fromKey
+		if m.typ == msgRemoteForward || m.typ == msgRemoteNotify || m.typ == msgKeyRequest {
+			key = m.toKey
+		}
+		if name, o := r.ring.get(key); o {
+			idx, _ := strconv.Atoi(name[len(routerWorkerPrefix):])
+			return r.workers[idx]
+		}
+		return r.workers[0]
+	default:
+		idx := atomic.AddUint64(&r.counter, 1) - 1
+		return r.workers[idx%uint64(n)]
+	}
+}
+
+const routerWorkerPrefix = "worker-"
+
+// worker在哈希环中的名字
+func routerWorkerName(idx int) string {
+	return routerWorkerPrefix + strconv.Itoa(idx)
+}
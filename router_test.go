@@ -0,0 +1,216 @@
+package gproc
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 创建worker计数的路由器
+func newCountingRouter(strategy RouterStrategy, size int, counts []int32) *Router {
+	var idx int32 = -1
+	return NewRouter(strategy, size, func() *LocalService {
+		i := atomic.AddInt32(&idx, 1)
+		s := NewDefaultLocalService()
+		s.RegisterHandle(MsgIdGetItemList, func(sender ISender, args interface{}) {
+			atomic.AddInt32(&counts[i], 1)
+			sender.Send(MsgIdGetItemList, i)
+		})
+		return s
+	})
+}
+
+// 发送count个请求并等待全部返回
+func requestThroughRouter(t *testing.T, router *Router, key int32, count int) {
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(router, key)
+	recvd := 0
+	r.RegisterCallback(MsgIdGetItemList, func(interface{}) {
+		recvd += 1
+	})
+	for i := 0; i < count; i++ {
+		if err := r.Request(MsgIdGetItemList, nil); err != nil {
+			t.Fatalf("request err: %v", err)
+		}
+	}
+	deadline := time.Now().Add(time.Second * 2)
+	for recvd < count && time.Now().Before(deadline) {
+		p.Update()
+		time.Sleep(time.Millisecond)
+	}
+	if recvd != count {
+		t.Fatalf("expect %v responses, got %v", count, recvd)
+	}
+}
+
+func TestRouterRoundRobin(t *testing.T) {
+	counts := make([]int32, 4)
+	router := newCountingRouter(RouterRoundRobin, 4, counts)
+	go router.Run()
+	defer router.Close()
+
+	requestThroughRouter(t, router, 1, 40)
+	for i := range counts {
+		if c := atomic.LoadInt32(&counts[i]); c != 10 {
+			t.Errorf("worker %v expect 10 requests, got %v", i, c)
+		}
+	}
+}
+
+func TestRouterConsistentHash(t *testing.T) {
+	counts := make([]int32, 4)
+	router := newCountingRouter(RouterConsistentHash, 4, counts)
+	go router.Run()
+	defer router.Close()
+
+	requestThroughRouter(t, router, 7, 20)
+	served := 0
+	for i := range counts {
+		if atomic.LoadInt32(&counts[i]) > 0 {
+			served += 1
+		}
+	}
+	if served != 1 {
+		t.Fatalf("expect one worker serve the key, got %v", served)
+	}
+}
+
+func TestRouterResize(t *testing.T) {
+	counts := make([]int32, 3)
+	router := newCountingRouter(RouterRoundRobin, 1, counts)
+	go router.Run()
+	defer router.Close()
+
+	// 报名要复制到新加入的worker，否则新worker上的转发找不到目标
+	p1 := NewDefaultResponseHandler()
+	p2 := NewDefaultResponseHandler()
+	defer p1.Close()
+	defer p2.Close()
+	r1 := p1.CreateRequester(router, int32(1))
+	r2 := p2.CreateRequester(router, int32(2))
+	chats := 0
+	r2.RegisterForward(MsgIdChat, func(interface{}, interface{}) {
+		chats += 1
+	})
+
+	router.Resize(3)
+	if router.Size() != 3 {
+		t.Fatalf("expect 3 workers, got %v", router.Size())
+	}
+	for i := 0; i < 3; i++ {
+		r1.RequestForward(int32(2), MsgIdChat, &msgChat{})
+	}
+	deadline := time.Now().Add(time.Second * 2)
+	for chats < 3 && time.Now().Before(deadline) {
+		p2.Update()
+		time.Sleep(time.Millisecond)
+	}
+	if chats != 3 {
+		t.Fatalf("expect 3 chats through resized router, got %v", chats)
+	}
+
+	router.Resize(1)
+	requestThroughRouter(t, router, 3, 5)
+}
+
+func TestRouterSignUpFullWorker(t *testing.T) {
+	router := NewRouter(RouterRoundRobin, 2, func() *LocalService {
+		return NewLocalService(1)
+	})
+	defer router.Close()
+	// worker 0的邮箱已满
+	m := getMsg()
+	m.typ = msgExec
+	m.args = func() {}
+	router.Worker(0).recv(m)
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	signed := make(chan struct{})
+	go func() {
+		p.CreateRequester(router, int32(1))
+		close(signed)
+	}()
+	// 报名等待worker 0时不占用路由器的锁
+	registered := make(chan struct{})
+	go func() {
+		router.RegisterHandle(MsgIdGetItemList, func(ISender, interface{}) {})
+		close(registered)
+	}()
+	select {
+	case <-registered:
+	case <-time.After(time.Second):
+		t.Fatal("router locked by blocked sign up")
+	}
+	go router.Run()
+	select {
+	case <-signed:
+	case <-time.After(time.Second):
+		t.Fatal("sign up not finished after worker run")
+	}
+}
+
+func TestRouterResizeKeepMessages(t *testing.T) {
+	counts := make([]int32, 4)
+	router := newCountingRouter(RouterRoundRobin, 2, counts)
+	defer router.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(router, int32(1))
+	recvd := 0
+	r.RegisterCallback(MsgIdGetItemList, func(interface{}) {
+		recvd += 1
+	})
+	// 没有运行时被移除的worker邮箱中的请求交给剩下的worker
+	for i := 0; i < 4; i++ {
+		r.Request(MsgIdGetItemList, nil)
+	}
+	router.Resize(1)
+	go router.Run()
+	waitUntil(t, func() bool { return recvd == 4 }, p)
+	if c := atomic.LoadInt32(&counts[0]); c != 4 {
+		t.Fatalf("expect worker 0 serve 4 requests, got %v", c)
+	}
+
+	// 运行中被移除的worker处理完已有的请求再关闭
+	router.Resize(2)
+	for i := 0; i < 10; i++ {
+		r.Request(MsgIdGetItemList, nil)
+	}
+	router.Resize(1)
+	waitUntil(t, func() bool { return recvd == 14 }, p)
+}
+
+func TestRouterResizeFullWorker(t *testing.T) {
+	dl := NewDeadLetters(10)
+	old := GetDeadLetters()
+	SetDeadLetters(dl)
+	defer SetDeadLetters(old)
+
+	router := NewRouter(RouterRoundRobin, 2, func() *LocalService {
+		return NewLocalService(2)
+	})
+	defer router.Close()
+	// 没有运行时两个worker的邮箱都满了
+	for i := 0; i < 4; i++ {
+		m := getMsg()
+		m.id = MsgIdGetItemList
+		router.recv(m)
+	}
+	// 剩下的worker放不下的消息作为死信，不阻塞调整大小
+	done := make(chan struct{})
+	go func() {
+		router.Resize(1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("resize blocked by full worker")
+	}
+	if c := dl.Count(DeadLetterWorkerRetired); c != 2 {
+		t.Fatalf("expect 2 worker retired dead letters, got %v", c)
+	}
+}
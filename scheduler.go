@@ -0,0 +1,227 @@
+package gproc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SchedulerMaxMsgsPerTurn = 100 // 默认每个服务每轮最多处理的消息数
+)
+
+// 调度器，M个服务由N个worker执行，服务有消息时才放入运行队列
+// 同一个服务同一时间只会被一个worker执行，每轮最多处理maxMsgsPerTurn条消息后让出，保证公平
+type Scheduler struct {
+	locker         sync.Mutex
+	cond           *sync.Cond
+	queue          []*LocalService
+	services       map[*LocalService]struct{}
+	workerNum      int
+	maxMsgsPerTurn int
+	closed         bool
+	chClose        chan struct{}
+	wg             sync.WaitGroup
+}
+
+// 创建调度器
+func NewScheduler(workerNum int, maxMsgsPerTurn int) *Scheduler {
+	s := &Scheduler{}
+	s.Init(workerNum, maxMsgsPerTurn)
+	return s
+}
+
+// 初始化
+func (s *Scheduler) Init(workerNum int, maxMsgsPerTurn int) {
+	if workerNum <= 0 {
+		workerNum = 1
+	}
+	if maxMsgsPerTurn <= 0 {
+		maxMsgsPerTurn = SchedulerMaxMsgsPerTurn
+	}
+	s.cond = sync.NewCond(&s.locker)
+	s.services = make(map[*LocalService]struct{})
+	s.workerNum = workerNum
+	s.maxMsgsPerTurn = maxMsgsPerTurn
+	s.chClose = make(chan struct{})
+}
+
+// 启动worker
+func (s *Scheduler) Start() {
+	s.wg.Add(s.workerNum + 1)
+	for i := 0; i < s.workerNum; i++ {
+		go s.runWorker()
+	}
+	go s.runTicker()
+}
+
+// 关闭调度器，等待worker退出，再停止还在调度器中的服务，邮箱中没处理的消息作为死信
+func (s *Scheduler) Close() {
+	s.locker.Lock()
+	if s.closed {
+		s.locker.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.chClose)
+	s.cond.Broadcast()
+	s.locker.Unlock()
+	s.wg.Wait()
+
+	// worker都已退出，服务不会再被执行
+	s.locker.Lock()
+	services := s.services
+	s.services = make(map[*LocalService]struct{})
+	s.queue = nil
+	s.locker.Unlock()
+	for service := range services {
+		service.handler.stop()
+	}
+}
+
+// 把服务交给调度器执行，服务不能再调用Run
+func (s *Scheduler) Spawn(service *LocalService) error {
+	s.locker.Lock()
+	if s.closed {
+		s.locker.Unlock()
+		return ErrClosed
+	}
+	if service.scheduler != nil {
+		s.locker.Unlock()
+		return ErrServiceScheduled
+	}
+	if err := service.handler.start(); err != nil {
+		s.locker.Unlock()
+		return err
+	}
+	service.scheduler = s
+	service.lastTick = time.Now()
+	service.handler.signal.Store(func() {
+		s.schedule(service)
+	})
+	s.services[service] = struct{}{}
+	s.locker.Unlock()
+	// Spawn之前已经在邮箱中的消息
+	if len(service.handler.ch) > 0 {
+		s.schedule(service)
+	}
+	return nil
+}
+
+// 服务数量
+func (s *Scheduler) ServiceCount() int {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return len(s.services)
+}
+
+// 把服务放入运行队列，已在队列中或正在执行的不重复放入
+func (s *Scheduler) schedule(service *LocalService) {
+	if !atomic.CompareAndSwapInt32(&service.scheduled, 0, 1) {
+		return
+	}
+	s.locker.Lock()
+	s.queue = append(s.queue, service)
+	s.locker.Unlock()
+	s.cond.Signal()
+}
+
+// 从运行队列取出服务
+func (s *Scheduler) pop() *LocalService {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	for len(s.queue) == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return nil
+	}
+	service := s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	return service
+}
+
+// worker循环
+func (s *Scheduler) runWorker() {
+	defer s.wg.Done()
+	for {
+		service := s.pop()
+		if service == nil {
+			return
+		}
+		s.runTurn(service)
+	}
+}
+
+// 执行服务一轮
+func (s *Scheduler) runTurn(service *LocalService) {
+	select {
+	case <-service.handler.chClose:
+		s.locker.Lock()
+		delete(s.services, service)
+		s.locker.Unlock()
+		service.handler.stop()
+		return
+	default:
+	}
+
+	for i := 0; i < s.maxMsgsPerTurn; i++ {
+		var m *msg
+		select {
+		case m = <-service.handler.ch:
+		default:
+		}
+		if m == nil {
+			break
+		}
+		service.processMsg(m)
+	}
+
+	now := time.Now()
+	service.responseHandler.runTimers(now)
+	rh := service.requestHandler
+	if rh.tickHandle != nil {
+		if tick := now.Sub(service.lastTick); tick >= rh.tick {
+			rh.tickHandle(tick)
+			service.lastTick = now
+		}
+	}
+
+	atomic.StoreInt32(&service.scheduled, 0)
+	// 清除标记前后进来的消息和关闭可能没有触发调度，这里再检查一次
+	if len(service.handler.ch) > 0 {
+		s.schedule(service)
+		return
+	}
+	select {
+	case <-service.handler.chClose:
+		s.schedule(service)
+	default:
+	}
+}
+
+// 定时把有定时器处理或者有定时器的服务放入运行队列
+func (s *Scheduler) runTicker() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(ServiceTickDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			var services []*LocalService
+			s.locker.Lock()
+			for service := range s.services {
+				if service.requestHandler.tickHandle != nil || service.responseHandler.timers.hasPending() {
+					services = append(services, service)
+				}
+			}
+			s.locker.Unlock()
+			for _, service := range services {
+				s.schedule(service)
+			}
+		case <-s.chClose:
+			return
+		}
+	}
+}
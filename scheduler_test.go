@@ -0,0 +1,159 @@
+package gproc
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSchedulerManyServices(t *testing.T) {
+	scheduler := NewScheduler(4, 8)
+	scheduler.Start()
+	defer scheduler.Close()
+
+	serviceCount := 1000
+	var concurrent int32
+	services := make([]*LocalService, serviceCount)
+	for i := 0; i < serviceCount; i++ {
+		s := NewLocalService(16)
+		var running int32
+		s.RegisterHandle(MsgIdIncrCounter, func(sender ISender, args interface{}) {
+			// 同一个服务不能同时被两个worker执行
+			if atomic.AddInt32(&running, 1) != 1 {
+				atomic.AddInt32(&concurrent, 1)
+			}
+			sender.Send(MsgIdIncrCounter, args)
+			atomic.AddInt32(&running, -1)
+		})
+		if err := scheduler.Spawn(s); err != nil {
+			t.Fatalf("spawn service err: %v", err)
+		}
+		services[i] = s
+		defer s.Close()
+	}
+	if err := services[0].Run(); err != ErrServiceScheduled {
+		t.Fatalf("run scheduled service expect ErrServiceScheduled, got %v", err)
+	}
+
+	perService := 5
+	expect := serviceCount * perService
+	// 返回通道足够大，worker不会阻塞在返回上
+	p := NewResponseHandler(newHandler(int32(expect)))
+	defer p.Close()
+	recvd := 0
+	for i, s := range services {
+		r := p.CreateRequester(s, i)
+		if i == 0 {
+			r.RegisterCallback(MsgIdIncrCounter, func(interface{}) {
+				recvd += 1
+			})
+		}
+	}
+	for n := 0; n < perService; n++ {
+		for _, s := range services {
+			m := getMsg()
+			m.typ = msgNormal
+			m.sender = p
+			m.id = MsgIdIncrCounter
+			s.recv(m)
+		}
+	}
+	waitUntil(t, func() bool { return recvd == expect }, p)
+	if c := atomic.LoadInt32(&concurrent); c != 0 {
+		t.Fatalf("service executed concurrently %v times", c)
+	}
+}
+
+func TestSchedulerTick(t *testing.T) {
+	scheduler := NewScheduler(2, 0)
+	scheduler.Start()
+	defer scheduler.Close()
+
+	s := NewDefaultLocalService()
+	var ticks int32
+	s.SetTickHandle(func(time.Duration) {
+		atomic.AddInt32(&ticks, 1)
+	}, time.Millisecond*5)
+	scheduler.Spawn(s)
+	waitUntil(t, func() bool { return atomic.LoadInt32(&ticks) >= 3 })
+
+	s.Close()
+	waitUntil(t, func() bool { return scheduler.ServiceCount() == 0 })
+}
+
+func TestSchedulerCloseDuringTurn(t *testing.T) {
+	scheduler := NewScheduler(1, 0)
+	scheduler.Start()
+	defer scheduler.Close()
+
+	s := NewDefaultLocalService()
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var handled int32
+	s.RegisterHandle(MsgIdIncrCounter, func(sender ISender, args interface{}) {
+		if atomic.AddInt32(&handled, 1) == 1 {
+			close(entered)
+			<-release
+		}
+	})
+	// Spawn时其他goroutine正在发送
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(s, int32(1))
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			r.Request(MsgIdIncrCounter, nil)
+		}
+		close(sent)
+	}()
+	if err := scheduler.Spawn(s); err != nil {
+		t.Fatalf("spawn err: %v", err)
+	}
+	<-entered
+	<-sent
+	// 在执行中关闭，这一轮结束后服务也要退出
+	s.Close()
+	close(release)
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("service closed during turn not stopped")
+	}
+	waitUntil(t, func() bool { return scheduler.ServiceCount() == 0 })
+}
+
+func TestSchedulerCloseStopsServices(t *testing.T) {
+	dl := NewDeadLetters(10)
+	old := GetDeadLetters()
+	SetDeadLetters(dl)
+	defer SetDeadLetters(old)
+
+	scheduler := NewScheduler(1, 0)
+	s := NewDefaultLocalService()
+	if err := scheduler.Spawn(s); err != nil {
+		t.Fatalf("spawn err: %v", err)
+	}
+	// 没有启动worker，消息留在邮箱中
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(s, int32(1))
+	r.Request(MsgIdIncrCounter, nil)
+
+	scheduler.Close()
+	select {
+	case <-s.Done():
+	default:
+		t.Fatal("spawned service not stopped after scheduler close")
+	}
+	if s.IsRunning() || scheduler.ServiceCount() != 0 {
+		t.Fatal("spawned service still running after scheduler close")
+	}
+	// 报名和请求都作为死信
+	if c := dl.Count(DeadLetterClosed); c != 2 {
+		t.Fatalf("expect 2 closed dead letters, got %v", c)
+	}
+	if err := r.Request(MsgIdIncrCounter, nil); err != ErrClosed {
+		t.Fatalf("request after scheduler close expect ErrClosed, got %v", err)
+	}
+}
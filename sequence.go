@@ -0,0 +1,287 @@
+package gproc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 时序图格式
+type SequenceFormat int32
+
+const (
+	SequenceMermaid  SequenceFormat = iota // Mermaid的sequenceDiagram
+	SequencePlantUML                       // PlantUML
+)
+
+// 按名字解析时序图格式
+func ParseSequenceFormat(name string) (SequenceFormat, bool) {
+	switch strings.ToLower(name) {
+	case "mermaid":
+		return SequenceMermaid, true
+	case "plantuml":
+		return SequencePlantUML, true
+	}
+	return 0, false
+}
+
+// 时序图选项
+type SequenceOptions struct {
+	Format   SequenceFormat
+	Title    string
+	MsgNames map[uint32]string // 消息id的名字，优先使用
+	Registry *CodecRegistry    // 消息id和类型id相同时从注册表取名字，nil不使用
+	Keys     []interface{}     // 只输出与这些key相关的消息，按文本比较，空表示所有
+	Start    time.Time         // 时间窗口开始，零值表示不限制
+	End      time.Time         // 时间窗口结束，零值表示不限制
+}
+
+// 消息id的名字
+func (o *SequenceOptions) msgName(msgId uint32) string {
+	if name, ok := o.MsgNames[msgId]; ok {
+		return name
+	}
+	if o.Registry != nil {
+		if name, ok := o.Registry.Name(msgId); ok {
+			return name
+		}
+	}
+	return strconv.FormatUint(uint64(msgId), 10)
+}
+
+// 是否在时间窗口内
+func (o *SequenceOptions) inWindow(t time.Time) bool {
+	if !o.Start.IsZero() && t.Before(o.Start) {
+		return false
+	}
+	if !o.End.IsZero() && t.After(o.End) {
+		return false
+	}
+	return true
+}
+
+// 是否与过滤的key相关
+func (o *SequenceOptions) matchKey(keys ...interface{}) bool {
+	if len(o.Keys) == 0 {
+		return true
+	}
+	for _, k := range keys {
+		if k == nil {
+			continue
+		}
+		text := fmt.Sprint(k)
+		for _, f := range o.Keys {
+			if fmt.Sprint(f) == text {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 时序图中的箭头或注释
+type sequenceStep struct {
+	from   string // 为空时是注释
+	to     string
+	text   string
+	dashed bool // 返回用虚线
+}
+
+// 把消息轨迹转成时序图写入w
+// 请求、报名和转发从发起者的key指向服务，带请求序号的返回按发起者的key和序号配对后从处理请求的服务指向返回处理器，
+// 配对后key显示为持有它的返回处理器的名字，没有配对的返回和通知显示为注释，函数执行消息不显示
+func WriteSequenceDiagram(w io.Writer, events []*TraceEvent, options SequenceOptions) error {
+	var list []*TraceEvent
+	for _, e := range events {
+		if e.MsgType != msgExec.String() && options.inWindow(e.Time) {
+			list = append(list, e)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Time.Before(list[j].Time)
+	})
+
+	// 请求序号只在发起者的返回处理器内唯一，按发起者的key和请求序号配对，返回的目标key是发起者的key
+	// 同一个请求重试时后一次代替前一次，返回和最后一次发送配对
+	type pairKey struct {
+		key   string
+		reqId uint64
+	}
+	requests := make(map[pairKey]*TraceEvent)
+	paired := make(map[*TraceEvent]*TraceEvent)
+	owners := make(map[string]string)
+	for _, e := range list {
+		if e.ReqId == 0 {
+			continue
+		}
+		typ, _ := parseMsgType(e.MsgType)
+		if typ == msgNormal {
+			requests[pairKey{keyLabel(e.FromKey), e.ReqId}] = e
+			continue
+		}
+		if !typ.isResponse() {
+			continue
+		}
+		k := pairKey{keyLabel(e.ToKey), e.ReqId}
+		req, o := requests[k]
+		if !o {
+			continue
+		}
+		paired[e] = req
+		if e.Service != "" && req.FromKey != nil {
+			owners[fmt.Sprint(req.FromKey)] = e.Service
+		}
+		if typ == msgReply || typ == msgStreamEnd || typ == msgReplyError {
+			delete(requests, k)
+		}
+	}
+
+	party := func(key interface{}) string {
+		if key == nil {
+			return "unknown"
+		}
+		text := fmt.Sprint(key)
+		if owner, o := owners[text]; o {
+			return owner
+		}
+		return "key " + text
+	}
+	service := func(e *TraceEvent) string {
+		if e.Service == "" {
+			return "unnamed"
+		}
+		return e.Service
+	}
+
+	var steps []sequenceStep
+	for _, e := range list {
+		typ, _ := parseMsgType(e.MsgType)
+		name := options.msgName(e.MsgId)
+		if req, o := paired[e]; o {
+			if !options.matchKey(req.FromKey, req.ToKey) {
+				continue
+			}
+			step := sequenceStep{from: service(req), to: service(e), text: name, dashed: true}
+			switch typ {
+			case msgStreamChunk:
+				step.text = name + " chunk"
+			case msgStreamEnd:
+				step.text = "stream end"
+				if e.Args != nil {
+					step.text += " " + fmt.Sprint(e.Args)
+				}
+			case msgReplyError:
+				step.text = "error " + fmt.Sprint(e.Args)
+			}
+			steps = append(steps, step)
+			continue
+		}
+		if !options.matchKey(e.FromKey, e.ToKey) {
+			continue
+		}
+		switch typ {
+		case msgNormal:
+			text := name
+			if e.ReqId != 0 {
+				text += " req " + strconv.FormatUint(e.ReqId, 10)
+			}
+			steps = append(steps, sequenceStep{from: party(e.FromKey), to: service(e), text: text})
+		case msgSignup, msgSignOff:
+			steps = append(steps, sequenceStep{from: party(e.FromKey), to: service(e), text: e.MsgType})
+		case msgForward, msgRemoteForward, msgKeyRequest:
+			text := fmt.Sprintf("%v %v to %v", e.MsgType, name, keyLabel(e.ToKey))
+			steps = append(steps, sequenceStep{from: party(e.FromKey), to: service(e), text: text})
+		case msgForwarded:
+			steps = append(steps, sequenceStep{from: party(e.FromKey), to: service(e), text: name + " forwarded"})
+		case msgRemoteNotify:
+			steps = append(steps, sequenceStep{to: service(e), text: fmt.Sprintf("remote notify %v to %v", name, keyLabel(e.ToKey))})
+		default:
+			// 通知和没有配对的返回不知道发送者
+			steps = append(steps, sequenceStep{to: service(e), text: e.MsgType + " " + name})
+		}
+	}
+	return writeSequenceSteps(w, steps, options)
+}
+
+// key的文本
+func keyLabel(key interface{}) string {
+	if key == nil {
+		return "none"
+	}
+	return fmt.Sprint(key)
+}
+
+// 去掉时序图语法中有特殊含义的字符
+func sequenceText(s string) string {
+	return strings.NewReplacer("\n", " ", "\r", " ", ";", ",", "#", "no.", "\"", "'").Replace(s)
+}
+
+// 按格式输出
+func writeSequenceSteps(w io.Writer, steps []sequenceStep, options SequenceOptions) error {
+	// 参与者按第一次出现的顺序声明
+	ids := make(map[string]string)
+	var names []string
+	id := func(name string) string {
+		if i, o := ids[name]; o {
+			return i
+		}
+		i := "p" + strconv.Itoa(len(names)+1)
+		ids[name] = i
+		names = append(names, name)
+		return i
+	}
+	for _, s := range steps {
+		if s.from != "" {
+			id(s.from)
+		}
+		id(s.to)
+	}
+
+	b := bufio.NewWriter(w)
+	switch options.Format {
+	case SequencePlantUML:
+		b.WriteString("@startuml\n")
+		if options.Title != "" {
+			fmt.Fprintf(b, "title %v\n", sequenceText(options.Title))
+		}
+		for _, name := range names {
+			fmt.Fprintf(b, "participant \"%v\" as %v\n", sequenceText(name), ids[name])
+		}
+		for _, s := range steps {
+			if s.from == "" {
+				fmt.Fprintf(b, "note over %v : %v\n", ids[s.to], sequenceText(s.text))
+				continue
+			}
+			arrow := "->"
+			if s.dashed {
+				arrow = "-->"
+			}
+			fmt.Fprintf(b, "%v %v %v : %v\n", ids[s.from], arrow, ids[s.to], sequenceText(s.text))
+		}
+		b.WriteString("@enduml\n")
+	default:
+		b.WriteString("sequenceDiagram\n")
+		if options.Title != "" {
+			fmt.Fprintf(b, "    title %v\n", sequenceText(options.Title))
+		}
+		for _, name := range names {
+			fmt.Fprintf(b, "    participant %v as %v\n", ids[name], sequenceText(name))
+		}
+		for _, s := range steps {
+			if s.from == "" {
+				fmt.Fprintf(b, "    Note over %v: %v\n", ids[s.to], sequenceText(s.text))
+				continue
+			}
+			arrow := "->>"
+			if s.dashed {
+				arrow = "-->>"
+			}
+			fmt.Fprintf(b, "    %v%v%v: %v\n", ids[s.from], arrow, ids[s.to], sequenceText(s.text))
+		}
+	}
+	return b.Flush()
+}
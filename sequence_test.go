@@ -0,0 +1,125 @@
+package gproc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sequenceEvents() []*TraceEvent {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	return []*TraceEvent{
+		{Time: at(0), Service: "shop", MsgType: "signup", FromKey: int32(1001)},
+		{Time: at(1), Service: "shop", MsgType: "normal", MsgId: MsgIdBuyItem, FromKey: int32(1001), ReqId: 1},
+		{Time: at(2), Service: "player", MsgType: "reply", MsgId: MsgIdBuyItem, ToKey: int32(1001), ReqId: 1},
+		{Time: at(3), Service: "friend", MsgType: "forward", MsgId: MsgIdIncrCounter, FromKey: int32(1001), ToKey: int32(1002)},
+		{Time: at(4), Service: "player2", MsgType: "forwarded", MsgId: MsgIdIncrCounter, FromKey: int32(1001)},
+		{Time: at(5), Service: "shop", MsgType: "exec"},
+		{Time: at(6), Service: "shop", MsgType: "normal", MsgId: MsgIdGetItemList, FromKey: int32(1003)},
+		{Time: at(7), Service: "player", MsgType: "reply", MsgId: MsgIdUpdateFriendInfo},
+	}
+}
+
+func TestSequenceDiagramMermaid(t *testing.T) {
+	var b bytes.Buffer
+	err := WriteSequenceDiagram(&b, sequenceEvents(), SequenceOptions{
+		Title:    "buy",
+		MsgNames: map[uint32]string{MsgIdBuyItem: "BuyItem", MsgIdGetItemList: "GetItemList"},
+	})
+	if err != nil {
+		t.Fatalf("write err: %v", err)
+	}
+	expect := `sequenceDiagram
+    title buy
+    participant p1 as player
+    participant p2 as shop
+    participant p3 as friend
+    participant p4 as player2
+    participant p5 as key 1003
+    p1->>p2: signup
+    p1->>p2: BuyItem req 1
+    p2-->>p1: BuyItem
+    p1->>p3: forward 10 to 1002
+    p1->>p4: 10 forwarded
+    p5->>p2: GetItemList
+    Note over p1: reply 6
+`
+	if b.String() != expect {
+		t.Fatalf("unexpected diagram:\n%v", b.String())
+	}
+}
+
+func TestSequenceDiagramFilter(t *testing.T) {
+	registry := NewCodecRegistry(nil)
+	registry.Register(MsgIdBuyItem, "BuyItem", &buyItemReq{})
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	var b bytes.Buffer
+	err := WriteSequenceDiagram(&b, sequenceEvents(), SequenceOptions{
+		Format:   SequencePlantUML,
+		Registry: registry,
+		Keys:     []interface{}{"1001"},
+		Start:    start.Add(time.Millisecond),
+		End:      start.Add(3 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatalf("write err: %v", err)
+	}
+	expect := `@startuml
+participant "player" as p1
+participant "shop" as p2
+participant "friend" as p3
+p1 -> p2 : BuyItem req 1
+p2 --> p1 : BuyItem
+p1 -> p3 : forward 10 to 1002
+@enduml
+`
+	if b.String() != expect {
+		t.Fatalf("unexpected diagram:\n%v", b.String())
+	}
+	if strings.Contains(b.String(), "1003") {
+		t.Fatalf("filtered key should not appear")
+	}
+}
+
+// 两个返回处理器的请求序号相同，按发起者的key配对，重试时返回和最后一次发送配对
+func TestSequenceDiagramPairByOwner(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	events := []*TraceEvent{
+		{Time: at(0), Service: "shop", MsgType: "normal", MsgId: MsgIdBuyItem, FromKey: int32(1001), ReqId: 1},
+		{Time: at(1), Service: "shop", MsgType: "normal", MsgId: MsgIdBuyItem, FromKey: int32(2001), ReqId: 1},
+		// 1001的请求超时后重试
+		{Time: at(2), Service: "shop", MsgType: "normal", MsgId: MsgIdBuyItem, FromKey: int32(1001), ReqId: 1},
+		{Time: at(3), Service: "player2", MsgType: "reply", MsgId: MsgIdBuyItem, ToKey: int32(2001), ReqId: 1},
+		{Time: at(4), Service: "player1", MsgType: "reply", MsgId: MsgIdBuyItem, ToKey: int32(1001), ReqId: 1},
+		// 第一次发送的迟到返回没有可以配对的请求
+		{Time: at(5), Service: "player1", MsgType: "reply", MsgId: MsgIdBuyItem, ToKey: int32(1001), ReqId: 1},
+	}
+	var b bytes.Buffer
+	err := WriteSequenceDiagram(&b, events, SequenceOptions{
+		MsgNames: map[uint32]string{MsgIdBuyItem: "BuyItem"},
+	})
+	if err != nil {
+		t.Fatalf("write err: %v", err)
+	}
+	expect := `sequenceDiagram
+    participant p1 as player1
+    participant p2 as shop
+    participant p3 as player2
+    p1->>p2: BuyItem req 1
+    p3->>p2: BuyItem req 1
+    p1->>p2: BuyItem req 1
+    p2-->>p3: BuyItem
+    p2-->>p1: BuyItem
+    Note over p1: reply BuyItem
+`
+	if b.String() != expect {
+		t.Fatalf("unexpected diagram:\n%v", b.String())
+	}
+}
@@ -1,6 +1,8 @@
 package gproc
 
 import (
+	"context"
+	"sync"
 	"time"
 )
 
@@ -9,6 +11,17 @@ type LocalService struct {
 	handler         *handler
 	requestHandler  *RequestHandler
 	responseHandler *ResponseHandler
+	scheduler       *Scheduler      // 不为nil时由调度器的worker执行，不需要调用Run
+	scheduled       int32           // 是否已在调度器的运行队列中
+	lastTick        time.Time       // 调度器模式下上次定时器处理的时间
+	workerPool      *WorkerPool     // 执行Go提交的阻塞任务
+	workOnce        sync.Once       // 只创建一次workCtx
+	workCtx         context.Context // 服务关闭时取消，传给Go提交的任务
+	workLocker      sync.Mutex      // 保护workDone和workPosting
+	workDone        []func()        // 完成还没交给服务处理的结果
+	workPosting     bool            // 是否有取结果的执行消息在投递中
+	persistence     *Persistence    // 事件溯源的持久化
+	recorder        IRecorder       // 记录取出的消息
 }
 
 // 创建本地服务
@@ -29,6 +42,7 @@ func (s *LocalService) Init(chanLen int32) {
 	s.handler.Init(chanLen)
 	s.requestHandler = NewRequestHandler(s.handler)
 	s.responseHandler = NewResponseHandler(s.handler)
+	s.responseHandler.requests = s.requestHandler
 }
 
 // 默认初始化
@@ -40,6 +54,20 @@ func (s *LocalService) InitDefault() {
 func (s *LocalService) Close() {
 	s.requestHandler.Close()
 	s.responseHandler.Close()
+	// 调度器模式下再执行一轮，让调度器移除服务
+	if s.scheduler != nil {
+		s.scheduler.schedule(s)
+	}
+}
+
+// 是否运行中
+func (s *LocalService) IsRunning() bool {
+	return s.handler.IsRunning()
+}
+
+// 处理循环退出后关闭的通道
+func (s *LocalService) Done() <-chan struct{} {
+	return s.handler.Done()
 }
 
 // 设置定时器处理
@@ -47,6 +75,11 @@ func (s *LocalService) SetTickHandle(h func(tick time.Duration), tick time.Durat
 	s.requestHandler.SetTickHandle(h, tick)
 }
 
+// 设置key目录
+func (s *LocalService) SetKeyDirectory(directory IKeyDirectory, node string) {
+	s.requestHandler.SetKeyDirectory(directory, node)
+}
+
 // 注册请求处理器
 func (s *LocalService) RegisterHandle(msgId uint32, handle func(ISender, interface{})) {
 	s.requestHandler.RegisterHandle(msgId, handle)
@@ -58,8 +91,13 @@ func (s *LocalService) RegisterForward4NoTarget(msgId uint32, handle func(ISende
 }
 
 // 通知
-func (s *LocalService) Notify(toKey interface{}, msgId uint32, args interface{}) error {
-	return s.requestHandler.Notify(toKey, msgId, args)
+func (s *LocalService) Notify(toKey interface{}, msgId uint32, args interface{}, options ...SendOption) error {
+	return s.requestHandler.Notify(toKey, msgId, args, options...)
+}
+
+// 添加定时器，只能在服务的goroutine中调用
+func (s *LocalService) AfterFunc(d time.Duration, f func()) *Timer {
+	return s.responseHandler.AfterFunc(d, f)
 }
 
 // 创建请求者
@@ -69,6 +107,13 @@ func (s *LocalService) NewRequester(receiver IRequestHandler, key interface{}, o
 
 // 循环处理请求
 func (s *LocalService) Run() error {
+	if s.scheduler != nil {
+		return ErrServiceScheduled
+	}
+	if err := s.handler.start(); err != nil {
+		return err
+	}
+	defer s.handler.stop()
 	var err error
 	if s.requestHandler.tickHandle != nil {
 		err = s.runProcessMsgAndTick()
@@ -86,6 +131,9 @@ func (s *LocalService) recv(m *msg) error {
 // 循环处理消息和定时器
 func (s *LocalService) runProcessMsgAndTick() error {
 	ticker := time.NewTicker(time.Duration(time.Millisecond * time.Duration(s.requestHandler.tick)))
+	defer ticker.Stop()
+	timerTicker := time.NewTicker(ServiceTickDuration)
+	defer timerTicker.Stop()
 	lastTime := time.Now()
 	run := true
 	for run {
@@ -95,6 +143,8 @@ func (s *LocalService) runProcessMsgAndTick() error {
 				return ErrClosed
 			}
 			s.processMsg(r)
+		case now := <-timerTicker.C:
+			s.responseHandler.runTimers(now)
 		case <-ticker.C:
 			now := time.Now()
 			tick := now.Sub(lastTime)
@@ -109,6 +159,8 @@ func (s *LocalService) runProcessMsgAndTick() error {
 
 // 循环处理请求
 func (s *LocalService) runProcessMsg() error {
+	timerTicker := time.NewTicker(ServiceTickDuration)
+	defer timerTicker.Stop()
 	run := true
 	for run {
 		select {
@@ -117,6 +169,8 @@ func (s *LocalService) runProcessMsg() error {
 				return ErrClosed
 			}
 			s.processMsg(r)
+		case now := <-timerTicker.C:
+			s.responseHandler.runTimers(now)
 		case <-s.handler.chClose:
 			run = false
 		}
@@ -124,11 +178,27 @@ func (s *LocalService) runProcessMsg() error {
 	return nil
 }
 
-// 处理消息，包括请求和返回的结果
+// 处理消息，包括请求和返回的结果，按消息类型分发，消息在这里统一回收
 func (s *LocalService) processMsg(r *msg) {
-	// 处理外部请求
-	if !s.requestHandler.handleMsg(r) {
+	if s.recorder != nil {
+		recordMsg(s.recorder, s.handler.name, r)
+	}
+	if info := s.handler.beginMsgLog(r); info != nil {
+		defer s.handler.endMsgLog(info)
+	}
+	span := beginHandleSpan(s.handler, r)
+	if r.typ.isResponse() {
 		// 遍历内部IRequester处理返回结果
-		s.responseHandler.handleResp(r)
+		if !s.responseHandler.handleResp(r) {
+			s.handler.logMsg(LogLevelWarn, LogEventUnclaimedResponse, r)
+			publishDeadLetter(DeadLetterUnclaimedResponse, r, nil)
+		}
+	} else if err := s.requestHandler.handleMsg(r); err != nil {
+		// 处理外部请求
+		s.handler.logMsgError(r, err)
+		publishDeadLetter(requestDeadLetterReason(r), r, err)
 	}
+	endHandleSpan(s.handler, span)
+	putMsg(r)
+	s.requestHandler.replayUnstashed()
 }
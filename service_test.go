@@ -3,7 +3,6 @@ package gproc
 import (
 	//"log"
 	"math/rand"
-	"sync"
 	"testing"
 	"time"
 )
@@ -171,6 +170,7 @@ type Player struct {
 	itemList        []*Item
 	friendList      []int32 // 好友列表
 	step            int     // 步骤
+	responses       int     // 收到的商店返回数
 }
 
 // 玩家初始化配置
@@ -202,10 +202,12 @@ func (p *Player) CreateShopRequester(shop *ShopService) {
 // 注册回调
 func (p *Player) RegisterShopHandlers() {
 	p.shopRequester.RegisterCallback(MsgIdGetItemList, func(param interface{}) {
+		p.responses += 1
 		//resp := param.(*GetItemListResp)
 		//log.Printf("get item list: %v", resp.itemList)
 	})
 	p.shopRequester.RegisterCallback(MsgIdBuyItem, func(param interface{}) {
+		p.responses += 1
 		resp := param.(*BuyItemResp)
 		if resp.Err < 0 {
 			//log.Printf("buy item %v failed, err %v, count %v", resp.instId, resp.Err, resp.count)
@@ -239,25 +241,27 @@ func (p *Player) BuyItem(instId int32, count int32) {
 	p.shopRequester.Request(MsgIdBuyItem, &BuyItemReq{instId: instId, count: count, totalMoney: p.money})
 }
 
-// 循环处理
-func (p *Player) Run(wg *sync.WaitGroup) {
-	defer wg.Done()
+// 逐步执行的对象
+type stepper interface {
+	Step() bool
+}
 
-	for i := 0; i < 1000; i++ {
-		r := rand.Int31n(2)
-		if r == 0 {
-			p.GetItemList()
-		} else {
-			itemIdList := []int32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21}
-			idx := rand.Int31n(int32(len(itemIdList)))
-			p.BuyItem(itemIdList[idx], rand.Int31n(10)+1)
+// 在测试的goroutine中轮流处理所有对象的消息，直到都没有消息
+func stepUntilIdle(targets ...stepper) {
+	for {
+		idle := true
+		for _, t := range targets {
+			if t.Step() {
+				idle = false
+			}
+		}
+		if idle {
+			return
 		}
-		p.Update()
-		time.Sleep(time.Microsecond * 10)
 	}
 }
 
-// 商店服务测试
+// 商店服务测试，逐步执行，不依赖sleep和真实的定时器
 func TestShopService(t *testing.T) {
 	itemList := []*ShopItem{
 		{1, 1, 100, 10},
@@ -283,25 +287,56 @@ func TestShopService(t *testing.T) {
 	}
 	shop := NewShopService()
 	shop.Init()
+	shop.SetStepping(true)
+	defer shop.Close()
 	for i := 0; i < len(itemList); i++ {
 		shop.AddItem(itemList[i])
 	}
 
-	playerCount := 100000
-	var wg sync.WaitGroup
-	wg.Add(playerCount)
-
-	go shop.Run()
-	defer shop.Close()
-
-	rand.Seed(time.Now().UnixNano())
+	const playerCount, rounds = 100, 50
+	targets := []stepper{shop}
+	players := make([]*Player, playerCount)
 	for i := 0; i < playerCount; i++ {
 		p := NewPlayer(int32(i+1), &playerConfig{money: 100000})
+		p.SetStepping(true)
 		p.CreateShopRequester(shop)
 		p.RegisterShopHandlers()
-		go p.Run(&wg)
 		defer p.Close()
+		players[i] = p
+		targets = append(targets, p)
+		// 报名消息也不能超过商店的邮箱
+		stepUntilIdle(shop)
 	}
 
-	wg.Wait()
+	rnd := rand.New(rand.NewSource(1))
+	itemIdList := []int32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21}
+	now := time.Unix(0, 0)
+	for round := 0; round < rounds; round++ {
+		for _, p := range players {
+			if rnd.Int31n(2) == 0 {
+				p.GetItemList()
+			} else {
+				p.BuyItem(itemIdList[rnd.Int31n(int32(len(itemIdList)))], rnd.Int31n(10)+1)
+			}
+			// 商店的邮箱只有10，每个请求处理完再发下一个
+			stepUntilIdle(targets...)
+		}
+		// 每轮过去一个定时间隔，价格随时间变化
+		now = now.Add(shop.requestHandler.tick)
+		shop.RunTimers(now)
+	}
+
+	for _, p := range players {
+		if p.responses != rounds {
+			t.Fatalf("player %v expect %v responses, got %v", p.id, rounds, p.responses)
+		}
+		if p.money < 0 {
+			t.Fatalf("player %v money %v below zero", p.id, p.money)
+		}
+	}
+	for _, item := range shop.itemList {
+		if item.count < 0 {
+			t.Fatalf("item %v count %v below zero", item.instId, item.count)
+		}
+	}
 }
@@ -0,0 +1,437 @@
+package gproc
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	shardNamePrefix = "shard-"
+	ShardPostRetry  = time.Duration(10 * time.Millisecond) // 分片邮箱满时重新投递迁移的间隔
+)
+
+// 分片交接的回调，key从一个分片迁移到另一个分片时调用
+type ShardHandoff struct {
+	Export func(key interface{}, from *LocalService) interface{}      // 在原分片的goroutine中导出key的状态
+	Import func(key interface{}, state interface{}, to *LocalService) // 在新分片的goroutine中导入key的状态
+}
+
+// 按key分片的服务，每个key的请求、转发和通知都按一致性哈希路由到所属的分片
+// 分片之间通过key目录互相转交转发，所以不同分片上的key之间也能转发
+type ShardedService struct {
+	locker          sync.RWMutex
+	factory         func() *LocalService
+	shards          map[string]*LocalService
+	ring            *hashRing
+	directory       *KeyDirectory
+	nextId          int
+	signUpMap       map[interface{}]ISender
+	handleMap       map[uint32]func(ISender, interface{})
+	noTargetHandles map[uint32]func(ISender, interface{}, interface{})
+	handoff         ShardHandoff
+	importLocker    sync.Mutex             // 保护importing
+	importing       map[interface{}][]*msg // 迁移中还没导入的key，路由到它们的消息先缓存
+	running         bool
+	closed          bool
+	chClose         chan struct{}
+}
+
+// 创建分片服务，factory用来创建分片，为nil时创建默认的LocalService
+func NewShardedService(count int, factory func() *LocalService) *ShardedService {
+	s := &ShardedService{}
+	s.Init(count, factory)
+	return s
+}
+
+// 初始化
+func (s *ShardedService) Init(count int, factory func() *LocalService) {
+	if factory == nil {
+		factory = NewDefaultLocalService
+	}
+	if count <= 0 {
+		count = 1
+	}
+	s.factory = factory
+	s.shards = make(map[string]*LocalService)
+	s.ring = newHashRing(0)
+	s.directory = NewKeyDirectory()
+	s.signUpMap = make(map[interface{}]ISender)
+	s.importing = make(map[interface{}][]*msg)
+	s.handleMap = make(map[uint32]func(ISender, interface{}))
+	s.noTargetHandles = make(map[uint32]func(ISender, interface{}, interface{}))
+	s.chClose = make(chan struct{})
+	for i := 0; i < count; i++ {
+		s.AddShard()
+	}
+}
+
+// 设置分片交接回调，需在增删分片之前设置
+func (s *ShardedService) SetHandoff(handoff ShardHandoff) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.handoff = handoff
+}
+
+// 分片名列表
+func (s *ShardedService) Shards() []string {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+	names := make([]string, 0, len(s.shards))
+	for name := range s.shards {
+		names = append(names, name)
+	}
+	return names
+}
+
+// 获取分片
+func (s *ShardedService) Shard(name string) *LocalService {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+	return s.shards[name]
+}
+
+// key所属的分片名
+func (s *ShardedService) ShardOf(key interface{}) string {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+	name, _ := s.ring.get(key)
+	return name
+}
+
+// key所属的分片
+func (s *ShardedService) ShardFor(key interface{}) *LocalService {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+	return s.shardFor(key)
+}
+
+// 添加分片，返回分片名，已报名的key中属于新分片的会被迁移过去
+func (s *ShardedService) AddShard() string {
+	s.locker.Lock()
+	if s.closed {
+		s.locker.Unlock()
+		return ""
+	}
+	name := shardNamePrefix + strconv.Itoa(s.nextId)
+	s.nextId += 1
+	shard := s.factory()
+	shard.SetKeyDirectory(s.directory, name)
+	for id, handle := range s.handleMap {
+		shard.RegisterHandle(id, handle)
+	}
+	for id, handle := range s.noTargetHandles {
+		shard.RegisterForward4NoTarget(id, handle)
+	}
+	s.directory.AddNode(name, shard)
+	s.shards[name] = shard
+
+	owners := s.keyOwners()
+	s.ring.add(name)
+	exports := make(map[*LocalService][]*shardMove)
+	for key, from := range owners {
+		if to, _ := s.ring.get(key); to != from {
+			if move := s.newShardMove(key); move != nil {
+				exports[s.shards[from]] = append(exports[s.shards[from]], move)
+			}
+		}
+	}
+	if s.running {
+		go shard.Run()
+	}
+	handoff := s.handoff
+	s.locker.Unlock()
+
+	for from, moves := range exports {
+		s.postExport(from, moves, handoff)
+	}
+	return name
+}
+
+// 删除分片，分片上的key迁移到其他分片后关闭该分片，最后一个分片不能删除
+func (s *ShardedService) RemoveShard(name string) bool {
+	s.locker.Lock()
+	shard, o := s.shards[name]
+	if !o || len(s.shards) == 1 {
+		s.locker.Unlock()
+		return false
+	}
+	owners := s.keyOwners()
+	s.ring.remove(name)
+	delete(s.shards, name)
+	var moves []*shardMove
+	for key, from := range owners {
+		if from != name {
+			continue
+		}
+		if move := s.newShardMove(key); move != nil {
+			moves = append(moves, move)
+		}
+	}
+	handoff := s.handoff
+	running := s.running
+	s.locker.Unlock()
+
+	directory := s.directory
+	remove := func() {
+		s.exportKeys(shard, moves, handoff)
+		directory.RemoveNode(name)
+		shard.Close()
+	}
+	if running {
+		// 迁移的导出排在分片邮箱中已有的消息之后
+		s.post(shard, remove, func() {
+			s.postImports(moves, handoff)
+		})
+		return true
+	}
+	// 没有运行的分片已经不在路由中，也不会再被运行，在调用者的goroutine中处理完邮箱中已有的消息再导出
+	for shard.Step() {
+	}
+	remove()
+	return true
+}
+
+// 迁移的key
+type shardMove struct {
+	key    interface{}
+	sender ISender
+	state  interface{} // 原分片导出的状态
+}
+
+// 新的迁移，调用者加锁，导入之前路由到key的消息先缓存起来
+// 上一次迁移还没导入完的key不再迁移，导入时会交给它当前所属的分片
+func (s *ShardedService) newShardMove(key interface{}) *shardMove {
+	s.importLocker.Lock()
+	defer s.importLocker.Unlock()
+	if _, o := s.importing[key]; o {
+		return nil
+	}
+	s.importing[key] = nil
+	return &shardMove{key: key, sender: s.signUpMap[key]}
+}
+
+// 在原分片的goroutine中导出迁移的key，分片已关闭时不带状态直接导入
+func (s *ShardedService) postExport(from *LocalService, moves []*shardMove, handoff ShardHandoff) {
+	s.post(from, func() {
+		s.exportKeys(from, moves, handoff)
+	}, func() {
+		s.postImports(moves, handoff)
+	})
+}
+
+// 在原分片的goroutine中导出迁移的key并注销，排在原分片邮箱中已有的消息之后，导出的状态再交给新分片导入
+func (s *ShardedService) exportKeys(from *LocalService, moves []*shardMove, handoff ShardHandoff) {
+	for _, move := range moves {
+		if handoff.Export != nil {
+			move.state = handoff.Export(move.key, from)
+		}
+		m := getMsg()
+		m.typ = msgSignOff
+		m.fromKey = move.key
+		from.requestHandler.handleMsg(m)
+		putMsg(m)
+	}
+	s.postImports(moves, handoff)
+}
+
+// 把导出的状态交给key当前所属的分片导入，分片在投递前被删除时交给新的所属分片
+func (s *ShardedService) postImports(moves []*shardMove, handoff ShardHandoff) {
+	if len(moves) == 0 {
+		return
+	}
+	imports := make(map[*LocalService][]*shardMove)
+	s.locker.RLock()
+	if s.closed {
+		s.locker.RUnlock()
+		return
+	}
+	for _, move := range moves {
+		to := s.shardFor(move.key)
+		imports[to] = append(imports[to], move)
+	}
+	s.locker.RUnlock()
+	for to, moves := range imports {
+		to, moves := to, moves
+		s.post(to, func() {
+			s.importKeys(to, moves, handoff)
+		}, func() {
+			s.postImports(moves, handoff)
+		})
+	}
+}
+
+// 在新分片的goroutine中报名并导入迁移过来的key，迁移期间缓存的消息在当前消息处理完后按收到的顺序处理
+// 导入前key又属于别的分片时转交过去，导入之后的迁移排在这条消息之后，不会先于导入执行
+func (s *ShardedService) importKeys(to *LocalService, moves []*shardMove, handoff ShardHandoff) {
+	var owned, others []*shardMove
+	var buffered []*msg
+	s.locker.RLock()
+	s.importLocker.Lock()
+	for _, move := range moves {
+		if s.shardFor(move.key) != to {
+			others = append(others, move)
+			continue
+		}
+		owned = append(owned, move)
+		buffered = append(buffered, s.importing[move.key]...)
+		delete(s.importing, move.key)
+	}
+	s.importLocker.Unlock()
+	s.locker.RUnlock()
+	s.postImports(others, handoff)
+
+	for _, move := range owned {
+		m := getMsg()
+		m.typ = msgSignup
+		m.fromKey = move.key
+		m.sender = move.sender
+		to.requestHandler.handleMsg(m)
+		putMsg(m)
+		if handoff.Import != nil {
+			handoff.Import(move.key, move.state, to)
+		}
+	}
+	to.requestHandler.unstashed = append(to.requestHandler.unstashed, buffered...)
+}
+
+// 不阻塞地在分片的goroutine中执行函数，邮箱满时由一个goroutine定时重试，分片关闭时调用closed
+func (s *ShardedService) post(shard *LocalService, f func(), closed func()) {
+	m := getMsg()
+	m.typ = msgExec
+	m.args = f
+	err := shard.handler.offer(m)
+	if err == ErrMailboxFull {
+		go func() {
+			for err == ErrMailboxFull {
+				time.Sleep(ShardPostRetry)
+				err = shard.handler.offer(m)
+			}
+			if err != nil {
+				putMsg(m)
+				closed()
+			}
+		}()
+	} else if err != nil {
+		putMsg(m)
+		closed()
+	}
+}
+
+// 已报名的key当前所属的分片，调用者加锁
+func (s *ShardedService) keyOwners() map[interface{}]string {
+	owners := make(map[interface{}]string, len(s.signUpMap))
+	for key := range s.signUpMap {
+		owners[key], _ = s.ring.get(key)
+	}
+	return owners
+}
+
+// key所属的分片，调用者加锁
+func (s *ShardedService) shardFor(key interface{}) *LocalService {
+	name, o := s.ring.get(key)
+	if !o {
+		return nil
+	}
+	return s.shards[name]
+}
+
+// 注册请求处理器，所有分片共用
+func (s *ShardedService) RegisterHandle(msgId uint32, handle func(ISender, interface{})) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.handleMap[msgId] = handle
+	for _, shard := range s.shards {
+		shard.RegisterHandle(msgId, handle)
+	}
+}
+
+// 注册无法找到目标的转发处理器
+func (s *ShardedService) RegisterForward4NoTarget(msgId uint32, handle func(ISender, interface{}, interface{})) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.noTargetHandles[msgId] = handle
+	for _, shard := range s.shards {
+		shard.RegisterForward4NoTarget(msgId, handle)
+	}
+}
+
+// 通知key，由key所属的分片发送，可在任意goroutine中调用
+func (s *ShardedService) Notify(toKey interface{}, msgId uint32, args interface{}, options ...SendOption) error {
+	m := getMsg()
+	m.typ = msgRemoteNotify
+	m.toKey = toKey
+	m.id = msgId
+	m.args = args
+	m.headers = sendHeaders(nil, options)
+	return s.recv(m)
+}
+
+// 运行所有分片，阻塞到关闭
+func (s *ShardedService) Run() error {
+	s.locker.Lock()
+	if s.closed {
+		s.locker.Unlock()
+		return ErrClosed
+	}
+	s.running = true
+	for _, shard := range s.shards {
+		go shard.Run()
+	}
+	s.locker.Unlock()
+	<-s.chClose
+	return nil
+}
+
+// 关闭所有分片
+func (s *ShardedService) Close() {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for _, shard := range s.shards {
+		shard.Close()
+	}
+	// 还没导入的key缓存的消息不会再处理
+	s.importLocker.Lock()
+	for key, buffered := range s.importing {
+		for _, m := range buffered {
+			publishDeadLetter(DeadLetterClosed, m, ErrClosed)
+			putMsg(m)
+		}
+		delete(s.importing, key)
+	}
+	s.importLocker.Unlock()
+	close(s.chClose)
+}
+
+// 接收消息，按key路由到分片
+func (s *ShardedService) recv(m *msg) error {
+	if m.typ == msgSignup {
+		s.locker.Lock()
+		s.signUpMap[m.fromKey] = m.sender
+		s.locker.Unlock()
+	}
+	s.locker.RLock()
+	if s.closed {
+		s.locker.RUnlock()
+		return ErrClosed
+	}
+	key := m.fromKey
+	if m.typ == msgRemoteForward || m.typ == msgRemoteNotify || m.typ == msgKeyRequest {
+		key = m.toKey
+	}
+	s.importLocker.Lock()
+	if buffered, o := s.importing[key]; o {
+		s.importing[key] = append(buffered, m)
+		s.importLocker.Unlock()
+		s.locker.RUnlock()
+		return nil
+	}
+	s.importLocker.Unlock()
+	shard := s.shardFor(key)
+	s.locker.RUnlock()
+	return shard.recv(m)
+}
@@ -0,0 +1,222 @@
+package gproc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	MsgIdIncrCounter = 10
+)
+
+// 每个分片保存自己负责的key的计数
+type counterShards struct {
+	locker sync.Mutex
+	states map[*LocalService]map[interface{}]int
+}
+
+func (c *counterShards) state(s *LocalService) map[interface{}]int {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.states[s]
+}
+
+func newCounterShardedService(count int) *ShardedService {
+	c := &counterShards{states: make(map[*LocalService]map[interface{}]int)}
+	sharded := &ShardedService{}
+	sharded.SetHandoff(ShardHandoff{
+		Export: func(key interface{}, from *LocalService) interface{} {
+			st := c.state(from)
+			n := st[key]
+			delete(st, key)
+			return n
+		},
+		Import: func(key interface{}, state interface{}, to *LocalService) {
+			c.state(to)[key] = state.(int)
+		},
+	})
+	sharded.Init(count, func() *LocalService {
+		s := NewDefaultLocalService()
+		st := make(map[interface{}]int)
+		c.locker.Lock()
+		c.states[s] = st
+		c.locker.Unlock()
+		s.RegisterHandle(MsgIdIncrCounter, func(sender ISender, args interface{}) {
+			st[args] += 1
+			sender.Send(MsgIdIncrCounter, [2]int{args.(int), st[args]})
+		})
+		return s
+	})
+	return sharded
+}
+
+// 等待条件满足，期间更新ResponseHandler
+func waitUntil(t *testing.T, cond func() bool, handlers ...*ResponseHandler) {
+	deadline := time.Now().Add(time.Second * 2)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("wait timeout")
+		}
+		for _, h := range handlers {
+			h.Update()
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestShardedServiceForward(t *testing.T) {
+	sharded := NewShardedService(4, nil)
+	go sharded.Run()
+	defer sharded.Close()
+
+	// 找两个在不同分片的key
+	var k1, k2 int32 = 1, 2
+	for sharded.ShardOf(k2) == sharded.ShardOf(k1) {
+		k2 += 1
+	}
+
+	p1 := NewDefaultResponseHandler()
+	p2 := NewDefaultResponseHandler()
+	defer p1.Close()
+	defer p2.Close()
+	r1 := p1.CreateRequester(sharded, k1)
+	r2 := p2.CreateRequester(sharded, k2)
+	var chatFrom, ackFrom, notified interface{}
+	r2.RegisterForward(MsgIdChat, func(fromKey interface{}, args interface{}) {
+		chatFrom = fromKey
+		r2.RequestForward(fromKey, MsgIdChatAck, &msgChatAck{})
+	})
+	r1.RegisterForward(MsgIdChatAck, func(fromKey interface{}, args interface{}) {
+		ackFrom = fromKey
+	})
+	r1.RegisterNotify(MsgIdUpdateFriendInfo, func(args interface{}) {
+		notified = args
+	})
+
+	// 等待两个key都在分片上报名
+	waitUntil(t, func() bool {
+		_, _, o1 := sharded.directory.Lookup(k1)
+		_, _, o2 := sharded.directory.Lookup(k2)
+		return o1 && o2
+	})
+	r1.RequestForward(k2, MsgIdChat, &msgChat{})
+	waitUntil(t, func() bool { return ackFrom != nil }, p1, p2)
+	if chatFrom != k1 || ackFrom != k2 {
+		t.Fatalf("expect chat from %v and ack from %v, got %v and %v", k1, k2, chatFrom, ackFrom)
+	}
+
+	sharded.Notify(k1, MsgIdUpdateFriendInfo, "info")
+	waitUntil(t, func() bool { return notified != nil }, p1)
+}
+
+// 计数的请求者，incrAll给每个key加1并等待返回
+func newCounterRequesters(t *testing.T, sharded *ShardedService, keyCount int) (*ResponseHandler, func(expect int)) {
+	// 通道足够放下所有返回
+	p := NewResponseHandler(newHandler(int32(keyCount * 2)))
+	requesters := make([]IRequester, keyCount)
+	counts := make([]int, keyCount)
+	for i := 0; i < keyCount; i++ {
+		requesters[i] = p.CreateRequester(sharded, i)
+		// 同一个ResponseHandler下的返回由第一个注册了回调的requester处理，所以返回里带上key
+		requesters[i].RegisterCallback(MsgIdIncrCounter, func(args interface{}) {
+			resp := args.([2]int)
+			counts[resp[0]] = resp[1]
+		})
+	}
+	incrAll := func(expect int) {
+		for i := 0; i < keyCount; i++ {
+			requesters[i].Request(MsgIdIncrCounter, i)
+		}
+		waitUntil(t, func() bool {
+			for i := 0; i < keyCount; i++ {
+				if counts[i] != expect {
+					return false
+				}
+			}
+			return true
+		}, p)
+	}
+	return p, incrAll
+}
+
+func TestShardedServiceRebalance(t *testing.T) {
+	testShardedServiceRebalance(t, 20)
+}
+
+func TestShardedServiceRebalanceManyKeys(t *testing.T) {
+	testShardedServiceRebalance(t, 300)
+}
+
+func testShardedServiceRebalance(t *testing.T, keyCount int) {
+	sharded := newCounterShardedService(2)
+	go sharded.Run()
+	defer sharded.Close()
+
+	p, incrAll := newCounterRequesters(t, sharded, keyCount)
+	defer p.Close()
+	incrAll(1)
+	before := make([]string, keyCount)
+	for i := 0; i < keyCount; i++ {
+		before[i] = sharded.ShardOf(i)
+	}
+	name := sharded.AddShard()
+	moved := 0
+	for i := 0; i < keyCount; i++ {
+		if owner := sharded.ShardOf(i); owner != before[i] {
+			if owner != name {
+				t.Fatalf("key %v moved to %v, expect new shard %v", i, owner, name)
+			}
+			moved += 1
+		}
+	}
+	if moved == 0 {
+		t.Fatal("no key moved to new shard")
+	}
+	// 迁移后状态保留
+	incrAll(2)
+
+	if !sharded.RemoveShard(before[0]) {
+		t.Fatalf("remove shard %v failed", before[0])
+	}
+	incrAll(3)
+}
+
+func TestShardedServiceRebalanceBeforeRun(t *testing.T) {
+	sharded := newCounterShardedService(2)
+	defer sharded.Close()
+
+	// 没有运行时报名都在分片的邮箱中
+	p, incrAll := newCounterRequesters(t, sharded, 90)
+	defer p.Close()
+	// 没有运行时迁移不会占满邮箱，也不会阻塞运行
+	sharded.AddShard()
+	sharded.RemoveShard(sharded.ShardOf(0))
+	go sharded.Run()
+	incrAll(1)
+	incrAll(2)
+}
+
+func TestShardedServiceRebalanceUnderLoad(t *testing.T) {
+	sharded := newCounterShardedService(2)
+	go sharded.Run()
+	defer sharded.Close()
+
+	p, incrAll := newCounterRequesters(t, sharded, 50)
+	defer p.Close()
+	incrAll(1)
+	// 请求处理中反复增删分片，迁移中的请求在导入之后处理，计数不丢失
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			name := sharded.AddShard()
+			sharded.RemoveShard(name)
+		}
+	}()
+	for i := 2; i <= 10; i++ {
+		incrAll(i)
+	}
+	<-done
+	incrAll(11)
+}
@@ -0,0 +1,233 @@
+package gproc
+
+import (
+	"bufio"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// span种类
+const (
+	SpanKindRequest  = "request"  // 发起请求
+	SpanKindForward  = "forward"  // 发起转发
+	SpanKindNotify   = "notify"   // 发起通知
+	SpanKindHandle   = "handle"   // 处理请求、转发和通知
+	SpanKindCallback = "callback" // 处理返回
+)
+
+// span上下文，随消息传递，TraceId为0表示没有跟踪
+type SpanContext struct {
+	TraceId uint64
+	SpanId  uint64
+}
+
+// 是否有效
+func (c SpanContext) IsValid() bool {
+	return c.TraceId != 0
+}
+
+// 跟踪的一段，发起请求、转发和通知时各产生一个，处理函数和回调各产生一个子span
+type Span struct {
+	TraceId  uint64
+	SpanId   uint64
+	ParentId uint64      // 父span，0表示根
+	Kind     string      // 种类
+	Name     string      // 种类和消息id
+	Service  string      // 处理消息的服务或返回处理器的名字，发起时为空
+	Key      interface{} // 发起者的key或者通知的目标key
+	MsgId    uint32
+	Start    time.Time
+	End      time.Time
+}
+
+// 上下文
+func (s *Span) Context() SpanContext {
+	return SpanContext{TraceId: s.TraceId, SpanId: s.SpanId}
+}
+
+// span导出接口，在处理消息的goroutine中调用，需要线程安全
+type ISpanExporter interface {
+	Export(span *Span)
+}
+
+var spanExporter atomic.Value
+
+// 设置全局span导出器，nil表示关闭跟踪，关闭时消息不带span上下文
+func SetSpanExporter(exporter ISpanExporter) {
+	spanExporter.Store(&exporter)
+}
+
+// 获取全局span导出器，没有设置时返回nil
+func GetSpanExporter() ISpanExporter {
+	e, _ := spanExporter.Load().(*ISpanExporter)
+	if e == nil {
+		return nil
+	}
+	return *e
+}
+
+// 新的非0 id
+func newSpanId() uint64 {
+	for {
+		if id := rand.Uint64(); id != 0 {
+			return id
+		}
+	}
+}
+
+// 创建parent的子span，parent无效时开始新的跟踪
+func newSpan(parent SpanContext, kind string, msgId uint32) *Span {
+	s := &Span{
+		TraceId:  parent.TraceId,
+		SpanId:   newSpanId(),
+		ParentId: parent.SpanId,
+		Kind:     kind,
+		Name:     kind + " " + strconv.FormatUint(uint64(msgId), 10),
+		MsgId:    msgId,
+		Start:    time.Now(),
+	}
+	if s.TraceId == 0 {
+		s.TraceId = newSpanId()
+	}
+	return s
+}
+
+// 发起请求、转发或通知，返回消息带的span上下文，没有导出器时返回无效的上下文
+func startSendSpan(parent SpanContext, kind string, key interface{}, msgId uint32) SpanContext {
+	exporter := GetSpanExporter()
+	if exporter == nil {
+		return SpanContext{}
+	}
+	s := newSpan(parent, kind, msgId)
+	s.Key = key
+	s.End = s.Start
+	exporter.Export(s)
+	return s.Context()
+}
+
+// 开始处理带span上下文的消息，处理期间的当前span是子span，返回nil表示不跟踪
+func beginHandleSpan(h *handler, m *msg) *Span {
+	if !m.span.IsValid() || m.typ == msgExec || m.typ == msgSignup || m.typ == msgSignOff {
+		return nil
+	}
+	if GetSpanExporter() == nil {
+		return nil
+	}
+	kind := SpanKindHandle
+	if m.typ.isResponse() {
+		kind = SpanKindCallback
+	}
+	s := newSpan(m.span, kind, m.id)
+	s.Service = h.name
+	s.Key = m.fromKey
+	h.span = s.Context()
+	// 处理函数的返回带上处理的span
+	if sender, o := m.sender.(*requestSender); o {
+		sender.span = h.span
+	}
+	return s
+}
+
+// 结束处理，导出span
+func endHandleSpan(h *handler, s *Span) {
+	if s == nil {
+		return
+	}
+	h.span = SpanContext{}
+	s.End = time.Now()
+	if exporter := GetSpanExporter(); exporter != nil {
+		exporter.Export(s)
+	}
+}
+
+// 正在处理的消息的span上下文，只能在处理消息的goroutine中调用
+func (s *LocalService) SpanContext() SpanContext {
+	return s.handler.span
+}
+
+// 正在处理的返回的span上下文，只能在处理返回的goroutine中调用
+func (h *ResponseHandler) SpanContext() SpanContext {
+	return h.handler.span
+}
+
+// 正在处理的消息的span上下文，只能在处理消息的goroutine中调用
+func (h *RequestHandler) SpanContext() SpanContext {
+	return h.handler.span
+}
+
+// 当前span，requester发起请求时作为父span
+func (h *ResponseHandler) currentSpan() SpanContext {
+	return h.handler.span
+}
+
+// 内存span导出器，一般用于测试
+type MemorySpanExporter struct {
+	locker sync.Mutex
+	spans  []*Span
+}
+
+// 导出
+func (e *MemorySpanExporter) Export(span *Span) {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// 导出的span
+func (e *MemorySpanExporter) Spans() []*Span {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+// JSON文件span导出器，每行一个span
+type JSONFileSpanExporter struct {
+	locker  sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *json.Encoder
+	err     error
+}
+
+// 创建JSON文件span导出器
+func CreateJSONFileSpanExporter(path string) (*JSONFileSpanExporter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &JSONFileSpanExporter{file: f, writer: w, encoder: json.NewEncoder(w)}, nil
+}
+
+// 导出，写入失败后不再导出，错误由Close返回
+func (e *JSONFileSpanExporter) Export(span *Span) {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	if e.err != nil {
+		return
+	}
+	if err := e.encoder.Encode(span); err != nil {
+		// key不能编码成JSON时改成文本
+		s := *span
+		s.Key = keyText(span.Key)
+		e.err = e.encoder.Encode(&s)
+	}
+}
+
+// 刷新并关闭
+func (e *JSONFileSpanExporter) Close() error {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	if err := e.writer.Flush(); err != nil && e.err == nil {
+		e.err = err
+	}
+	if err := e.file.Close(); err != nil && e.err == nil {
+		e.err = err
+	}
+	return e.err
+}
@@ -0,0 +1,178 @@
+package gproc
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 按种类和服务查找span
+func findSpan(spans []*Span, kind, service string, key interface{}) *Span {
+	for _, s := range spans {
+		if s.Kind == kind && s.Service == service && (key == nil || s.Key == key) {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestSpanPropagation(t *testing.T) {
+	exporter := &MemorySpanExporter{}
+	SetSpanExporter(exporter)
+	defer SetSpanExporter(nil)
+
+	bag := NewDefaultLocalService()
+	bag.SetName("bag")
+	bag.RegisterHandle(MsgIdIncrCounter, func(sender ISender, args interface{}) {
+		sender.Send(MsgIdIncrCounter, args)
+	})
+	go bag.Run()
+	defer bag.Close()
+
+	shop := NewDefaultLocalService()
+	shop.SetName("shop")
+	toBag := shop.NewRequester(bag, "shop")
+	shop.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+		// 处理中发起的请求是处理span的子span
+		toBag.Request(MsgIdIncrCounter, args)
+		sender.Send(MsgIdBuyItem, args)
+	})
+	go shop.Run()
+	defer shop.Close()
+
+	client := NewDefaultResponseHandler()
+	client.SetName("client")
+	defer client.Close()
+	r1 := client.CreateRequester(shop, int32(1001))
+	r2 := client.CreateRequester(shop, int32(1002))
+	replied, forwarded := false, false
+	r1.RegisterCallback(MsgIdBuyItem, func(args interface{}) {
+		replied = true
+	})
+	r2.RegisterForward(MsgIdUpdateFriendInfo, func(fromKey interface{}, args interface{}) {
+		forwarded = true
+	})
+	r1.Request(MsgIdBuyItem, 1)
+	waitUntil(t, func() bool {
+		return replied && findSpan(exporter.Spans(), SpanKindCallback, "shop", nil) != nil
+	}, client)
+	r1.RequestForward(int32(1002), MsgIdUpdateFriendInfo, nil)
+	waitUntil(t, func() bool { return forwarded }, client)
+
+	spans := exporter.Spans()
+	request := findSpan(spans, SpanKindRequest, "", int32(1001))
+	handle := findSpan(spans, SpanKindHandle, "shop", int32(1001))
+	callback := findSpan(spans, SpanKindCallback, "client", nil)
+	if request == nil || handle == nil || callback == nil {
+		t.Fatalf("missing spans %v %v %v", request, handle, callback)
+	}
+	if request.ParentId != 0 || handle.ParentId != request.SpanId || callback.ParentId != handle.SpanId {
+		t.Fatalf("unexpected request span chain %+v %+v %+v", request, handle, callback)
+	}
+	if handle.TraceId != request.TraceId || callback.TraceId != request.TraceId {
+		t.Fatalf("spans expect same trace")
+	}
+	if handle.End.Before(handle.Start) {
+		t.Fatalf("handle span end before start")
+	}
+
+	// shop处理中发给bag的请求
+	bagRequest := findSpan(spans, SpanKindRequest, "", "shop")
+	bagHandle := findSpan(spans, SpanKindHandle, "bag", "shop")
+	bagCallback := findSpan(spans, SpanKindCallback, "shop", nil)
+	if bagRequest == nil || bagHandle == nil || bagCallback == nil {
+		t.Fatalf("missing nested spans")
+	}
+	if bagRequest.ParentId != handle.SpanId || bagHandle.ParentId != bagRequest.SpanId || bagCallback.ParentId != bagHandle.SpanId || bagCallback.TraceId != request.TraceId {
+		t.Fatalf("unexpected nested span chain %+v %+v %+v", bagRequest, bagHandle, bagCallback)
+	}
+
+	// 转发经过shop到达1002的持有者
+	forward := findSpan(spans, SpanKindForward, "", int32(1001))
+	var route, forwardedSpan *Span
+	for _, s := range spans {
+		if forward != nil && s.ParentId == forward.SpanId {
+			route = s
+		}
+	}
+	for _, s := range spans {
+		if route != nil && s.ParentId == route.SpanId {
+			forwardedSpan = s
+		}
+	}
+	if forward == nil || route == nil || forwardedSpan == nil {
+		t.Fatalf("missing forward spans")
+	}
+	if route.Service != "shop" || forwardedSpan.Kind != SpanKindCallback || forwardedSpan.Service != "client" || forward.ParentId != 0 {
+		t.Fatalf("unexpected forward span chain %+v %+v %+v", forward, route, forwardedSpan)
+	}
+}
+
+func TestSpanDisabled(t *testing.T) {
+	service := NewDefaultRequestHandler()
+	var sender ISender
+	service.RegisterHandle(MsgIdBuyItem, func(s ISender, args interface{}) {
+		sender = s
+		s.Send(MsgIdBuyItem, args)
+	})
+	go service.Run()
+	defer service.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(service, int32(1))
+	done := false
+	r.RegisterCallback(MsgIdBuyItem, func(args interface{}) {
+		done = true
+	})
+	r.Request(MsgIdBuyItem, nil)
+	waitUntil(t, func() bool { return done }, p)
+	// 没有导出器时不跟踪，sender还是持有者
+	if sender != p {
+		t.Fatalf("expect sender to be owner without tracing, got %T", sender)
+	}
+}
+
+func TestJSONFileSpanExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	exporter, err := CreateJSONFileSpanExporter(path)
+	if err != nil {
+		t.Fatalf("create exporter err: %v", err)
+	}
+	root := newSpan(SpanContext{}, SpanKindRequest, MsgIdBuyItem)
+	root.Key = int32(1)
+	child := newSpan(root.Context(), SpanKindHandle, MsgIdBuyItem)
+	child.Service = "shop"
+	child.Key = struct{ A func() }{}
+	exporter.Export(root)
+	exporter.Export(child)
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("close exporter err: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open err: %v", err)
+	}
+	defer f.Close()
+	var spans []Span
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var s Span
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			t.Fatalf("unmarshal err: %v", err)
+		}
+		spans = append(spans, s)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("expect 2 spans, got %v", len(spans))
+	}
+	if spans[1].TraceId != root.TraceId || spans[1].ParentId != root.SpanId || spans[1].Service != "shop" || spans[1].Name != child.Name {
+		t.Fatalf("unexpected child span %+v", spans[1])
+	}
+	if _, o := spans[1].Key.(string); !o {
+		t.Fatalf("unencodable key expect text, got %T", spans[1].Key)
+	}
+}
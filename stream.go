@@ -0,0 +1,181 @@
+package gproc
+
+import (
+	"time"
+)
+
+const (
+	StreamWindow           = 16                                   // 默认的流式请求额度
+	StreamGrantRetryPeriod = time.Duration(10 * time.Millisecond) // 处理器通道满时重新发回额度的间隔
+)
+
+// 流，处理函数通过OpenStream从流式请求的sender获得，只能在处理器的goroutine中使用
+// 每发送一个数据块消耗一个额度，请求者处理数据块后把额度发回处理器
+type Stream struct {
+	sender       *requestSender
+	credits      int
+	closed       bool
+	creditHandle func(credits int)
+}
+
+// 从流式请求的sender打开流，同一个请求多次打开返回同一个流
+func OpenStream(sender ISender) (*Stream, error) {
+	s, o := sender.(*requestSender)
+	if !o || s.window <= 0 || s.handler == nil {
+		return nil, ErrNotStreamRequest
+	}
+	if s.stream == nil {
+		s.stream = &Stream{sender: s, credits: s.window}
+	}
+	return s.stream, nil
+}
+
+// 剩余额度
+func (s *Stream) Credits() int {
+	return s.credits
+}
+
+// 设置额度增加时的处理函数，在处理器的goroutine中调用，一般在这里继续发送
+func (s *Stream) SetCreditHandle(handle func(credits int)) {
+	s.creditHandle = handle
+}
+
+// 发送数据块，没有额度时返回ErrStreamNoCredit，数据块没有发送
+func (s *Stream) Send(msgId uint32, chunk interface{}) error {
+	if s.closed {
+		return ErrStreamClosed
+	}
+	if s.credits <= 0 {
+		return ErrStreamNoCredit
+	}
+	if err := s.send(msgStreamChunk, msgId, chunk); err != nil {
+		return err
+	}
+	s.credits -= 1
+	return nil
+}
+
+// 正常结束
+func (s *Stream) Close() error {
+	return s.CloseWithError(nil)
+}
+
+// 带错误结束，结束不消耗额度
+func (s *Stream) CloseWithError(err error) error {
+	if s.closed {
+		return ErrStreamClosed
+	}
+	s.closed = true
+	return s.send(msgStreamEnd, 0, err)
+}
+
+// 发送到请求者的持有者
+func (s *Stream) send(typ msgType, msgId uint32, args interface{}) error {
+	m := getMsg()
+	m.typ = typ
+	m.sender = s.sender
+	m.toKey = s.sender.key
+	m.id = msgId
+	m.args = args
+	m.reqId = s.sender.reqId
+	m.span = s.sender.span
+	m.headers = s.sender.headers
+	return s.sender.owner.send(m)
+}
+
+// 增加额度
+func (s *Stream) grant(n int) {
+	s.credits += n
+	if !s.closed && s.creditHandle != nil {
+		s.creditHandle(s.credits)
+	}
+}
+
+// 请求者一侧进行中的流式请求
+type streamCall struct {
+	window   int
+	consumed int // 已处理还没发回的额度
+	onChunk  func(msgId uint32, chunk interface{})
+	onEnd    func(err error)
+}
+
+// 流式请求，window是处理器可以连续发送的数据块数，数据块按发送顺序交给onChunk，结束或出错时调用onEnd
+// 设置了请求超时时，超过这个时间没有收到数据块以ErrRequestTimeout结束
+// 额度处理了一半窗口才发回，结束时还没发回的额度不再发回，流结束后处理器也不再需要额度
+func (r *Requester) RequestStream(msgId uint32, args interface{}, window int, onChunk func(msgId uint32, chunk interface{}), onEnd func(err error)) error {
+	if window <= 0 {
+		window = StreamWindow
+	}
+	p := &pendingRequest{
+		reqId:    r.owner.nextRequestId(),
+		msgId:    msgId,
+		args:     args,
+		receiver: r.receiver,
+		stream:   &streamCall{window: window, onChunk: onChunk, onEnd: onEnd},
+	}
+	r.pending[p.reqId] = p
+	if err := r.sendPending(p, false); err != nil {
+		delete(r.pending, p.reqId)
+		return err
+	}
+	r.resetStreamTimer(p)
+	return nil
+}
+
+// 重新开始流的空闲超时
+func (r *Requester) resetStreamTimer(p *pendingRequest) {
+	if r.options.requestTimeout <= 0 {
+		return
+	}
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.timer = r.owner.AfterFunc(time.Duration(r.options.requestTimeout)*time.Millisecond, func() {
+		p.timer = nil
+		delete(r.pending, p.reqId)
+		p.stream.onEnd(ErrRequestTimeout)
+	})
+}
+
+// 处理流的数据块和结束
+func (r *Requester) handleStream(p *pendingRequest, m *msg) {
+	call := p.stream
+	if m.typ == msgStreamEnd {
+		if p.timer != nil {
+			p.timer.Stop()
+		}
+		delete(r.pending, p.reqId)
+		err, _ := m.args.(error)
+		call.onEnd(err)
+		return
+	}
+	r.resetStreamTimer(p)
+	call.onChunk(m.id, m.args)
+	// 处理了一半窗口的数据块后把额度发回处理器
+	call.consumed += 1
+	if call.consumed*2 >= call.window {
+		if s, o := m.sender.(*requestSender); o {
+			r.grantCredits(p, s, call.consumed)
+		}
+		call.consumed = 0
+	}
+}
+
+// 把额度发回处理请求的处理器，在处理器的goroutine中增加
+// 处理器可能正阻塞在发送数据块到持有者，通道满时不等待，稍后重试，流结束后不再重试
+func (r *Requester) grantCredits(p *pendingRequest, s *requestSender, n int) {
+	m := getMsg()
+	m.typ = msgExec
+	m.args = func() {
+		s.stream.grant(n)
+	}
+	m.noWait = true
+	if s.handler.recv(m) != ErrMailboxFull {
+		return
+	}
+	r.owner.AfterFunc(StreamGrantRetryPeriod, func() {
+		if _, o := r.pending[p.reqId]; o {
+			r.grantCredits(p, s, n)
+		}
+	})
+}
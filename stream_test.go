@@ -0,0 +1,160 @@
+package gproc
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// 分页返回物品列表，额度用完后等额度回来再继续
+func TestStream(t *testing.T) {
+	const total = 100
+	service := NewDefaultLocalService()
+	maxInflight := 0
+	service.RegisterHandle(MsgIdGetItemList, func(sender ISender, args interface{}) {
+		stream, err := OpenStream(sender)
+		if err != nil {
+			t.Errorf("open stream err: %v", err)
+			return
+		}
+		next := 0
+		write := func(credits int) {
+			if credits > maxInflight {
+				maxInflight = credits
+			}
+			for next < total {
+				if err := stream.Send(MsgIdGetItemList, next); err != nil {
+					if err != ErrStreamNoCredit {
+						t.Errorf("stream send err: %v", err)
+					}
+					return
+				}
+				next += 1
+			}
+			stream.Close()
+		}
+		stream.SetCreditHandle(write)
+		write(stream.Credits())
+	})
+	go service.Run()
+	defer service.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(service, "player")
+	var items []int
+	ended := false
+	err := r.RequestStream(MsgIdGetItemList, nil, 4, func(msgId uint32, chunk interface{}) {
+		if msgId != MsgIdGetItemList {
+			t.Errorf("unexpected chunk msg id %v", msgId)
+		}
+		items = append(items, chunk.(int))
+	}, func(err error) {
+		if err != nil {
+			t.Errorf("stream end err: %v", err)
+		}
+		ended = true
+	})
+	if err != nil {
+		t.Fatalf("request stream err: %v", err)
+	}
+	waitUntil(t, func() bool { return ended }, p)
+	if len(items) != total {
+		t.Fatalf("expect %v items, got %v", total, len(items))
+	}
+	for i, item := range items {
+		if item != i {
+			t.Fatalf("chunks out of order at %v: %v", i, item)
+		}
+	}
+	if maxInflight > 4 {
+		t.Fatalf("credits exceed window: %v", maxInflight)
+	}
+}
+
+func TestStreamCloseWithError(t *testing.T) {
+	errDump := errors.New("leaderboard dump failed")
+	service := NewDefaultLocalService()
+	service.RegisterHandle(MsgIdGetItemList, func(sender ISender, args interface{}) {
+		stream, _ := OpenStream(sender)
+		stream.Send(MsgIdGetItemList, "first")
+		stream.CloseWithError(errDump)
+		if err := stream.Send(MsgIdGetItemList, "after close"); err != ErrStreamClosed {
+			t.Errorf("expect ErrStreamClosed, got %v", err)
+		}
+	})
+	// 普通请求的sender不能打开流
+	service.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+		if _, err := OpenStream(sender); err != ErrNotStreamRequest {
+			t.Errorf("expect ErrNotStreamRequest, got %v", err)
+		}
+		sender.Send(MsgIdBuyItem, nil)
+	})
+	go service.Run()
+	defer service.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(service, "player")
+	var chunks []interface{}
+	var endErr error
+	r.RequestStream(MsgIdGetItemList, nil, 0, func(msgId uint32, chunk interface{}) {
+		chunks = append(chunks, chunk)
+	}, func(err error) {
+		endErr = err
+	})
+	waitUntil(t, func() bool { return endErr != nil }, p)
+	if endErr != errDump || len(chunks) != 1 || chunks[0] != "first" {
+		t.Fatalf("unexpected stream result %v %v", chunks, endErr)
+	}
+
+	bought := false
+	r.RegisterCallback(MsgIdBuyItem, func(interface{}) { bought = true })
+	r.Request(MsgIdBuyItem, nil)
+	waitUntil(t, func() bool { return bought }, p)
+}
+
+// 处理器通道满时发回额度不阻塞请求者，通道空出来后重试送达
+func TestStreamGrantMailboxFull(t *testing.T) {
+	producer := NewRequestHandler(newHandler(1))
+	defer producer.Close()
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	// 报名消息占满处理器的通道
+	r := p.CreateRequester(producer, "player").(*Requester)
+	pend := &pendingRequest{reqId: p.nextRequestId(), stream: &streamCall{window: 2}}
+	r.pending[pend.reqId] = pend
+	s := &requestSender{owner: p, key: "player", reqId: pend.reqId, window: 2, handler: producer}
+	stream, _ := OpenStream(s)
+	granted := make(chan int, 1)
+	stream.SetCreditHandle(func(credits int) { granted <- credits })
+
+	done := make(chan struct{})
+	go func() {
+		r.grantCredits(pend, s, 2)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("grant blocked on full mailbox")
+	}
+
+	go producer.Run()
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		p.Update()
+		select {
+		case credits := <-granted:
+			if credits != 4 {
+				t.Fatalf("expect 4 credits, got %v", credits)
+			}
+			return
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("grant not retried")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
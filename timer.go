@@ -0,0 +1,85 @@
+package gproc
+
+import (
+	"container/heap"
+	"sync/atomic"
+	"time"
+)
+
+// 定时器，在持有者的goroutine中到期执行
+type Timer struct {
+	when  time.Time
+	f     func()
+	index int // 在堆中的位置，-1表示已不在堆中
+	queue *timerQueue
+}
+
+// 停止定时器，返回是否在执行前停止
+func (t *Timer) Stop() bool {
+	if t.index < 0 {
+		return false
+	}
+	t.queue.remove(t)
+	return true
+}
+
+// 定时器堆
+type timerHeap []*Timer
+
+func (h timerHeap) Len() int           { return len(h) }
+func (h timerHeap) Less(i, j int) bool { return h[i].when.Before(h[j].when) }
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*Timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
+
+// 定时器队列，非线程安全，只在持有者的goroutine中使用
+type timerQueue struct {
+	timers  timerHeap
+	pending int32 // 定时器数量，供其他goroutine查询
+}
+
+// 添加定时器
+func (q *timerQueue) add(now time.Time, d time.Duration, f func()) *Timer {
+	t := &Timer{when: now.Add(d), f: f, queue: q}
+	heap.Push(&q.timers, t)
+	atomic.StoreInt32(&q.pending, int32(len(q.timers)))
+	return t
+}
+
+// 删除定时器
+func (q *timerQueue) remove(t *Timer) {
+	heap.Remove(&q.timers, t.index)
+	atomic.StoreInt32(&q.pending, int32(len(q.timers)))
+}
+
+// 执行到期的定时器
+func (q *timerQueue) run(now time.Time) {
+	for len(q.timers) > 0 && !q.timers[0].when.After(now) {
+		t := heap.Pop(&q.timers).(*Timer)
+		atomic.StoreInt32(&q.pending, int32(len(q.timers)))
+		t.f()
+	}
+}
+
+// 是否有定时器
+func (q *timerQueue) hasPending() bool {
+	return atomic.LoadInt32(&q.pending) > 0
+}
@@ -0,0 +1,293 @@
+package gproc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// 消息轨迹事件，服务从邮箱中取出一条消息时产生
+type TraceEvent struct {
+	Time     time.Time   // 取出的时间
+	Service  string      // 服务或返回处理器的名字
+	MsgType  string      // 消息类型: normal、signup、forward、reply等
+	MsgId    uint32      // 消息id
+	FromKey  interface{} // 发起者的key
+	ToKey    interface{} // 目标的key
+	ReqId    uint64      // 请求序号
+	Args     interface{} // 数据，从文件读取时类型没有注册的为nil
+	ArgsLost bool        // 数据类型没有注册，没有记录下来或者无法解码，这样的事件不能重放
+	ArgsText string      // 数据没有记录下来时的文本，只用于查看
+	Headers  Headers     // 消息头
+}
+
+// 记录器接口，在处理消息的goroutine中调用，多个服务共用时需要线程安全
+type IRecorder interface {
+	Record(e *TraceEvent)
+}
+
+// 设置名字，用于轨迹、跟踪和日志
+func (s *LocalService) SetName(name string) {
+	s.handler.name = name
+}
+
+// 名字
+func (s *LocalService) Name() string {
+	return s.handler.name
+}
+
+// 设置记录器，记录每条取出的消息
+func (s *LocalService) SetRecorder(recorder IRecorder) {
+	s.recorder = recorder
+}
+
+// 设置名字，用于轨迹、跟踪和日志
+func (h *ResponseHandler) SetName(name string) {
+	h.handler.name = name
+}
+
+// 名字
+func (h *ResponseHandler) Name() string {
+	return h.handler.name
+}
+
+// 设置名字，用于跟踪和日志
+func (h *RequestHandler) SetName(name string) {
+	h.handler.name = name
+}
+
+// 名字
+func (h *RequestHandler) Name() string {
+	return h.handler.name
+}
+
+// 设置记录器，记录每条取出的返回
+func (h *ResponseHandler) SetRecorder(recorder IRecorder) {
+	h.recorder = recorder
+}
+
+// 记录取出的消息
+func recordMsg(recorder IRecorder, service string, m *msg) {
+	recorder.Record(&TraceEvent{
+		Time:    time.Now(),
+		Service: service,
+		MsgType: m.typ.String(),
+		MsgId:   m.id,
+		FromKey: m.fromKey,
+		ToKey:   m.toKey,
+		ReqId:   m.reqId,
+		Args:    m.args,
+		Headers: m.headers,
+	})
+}
+
+// 轨迹文件中的记录
+type traceRecord struct {
+	Time     int64
+	Service  string
+	MsgType  string
+	MsgId    uint32
+	FromKey  interface{}
+	ToKey    interface{}
+	ReqId    uint64
+	ArgsType uint32
+	Args     []byte
+	ArgsText string // 数据类型没有注册时的文本，只用于查看
+	Headers  Headers
+}
+
+// 轨迹文件，数据用编解码注册表编码，key是基础类型之外时需要gob.Register
+type TraceFile struct {
+	locker   sync.Mutex
+	file     *os.File
+	registry *CodecRegistry
+	seq      uint64
+	err      error
+}
+
+// 创建轨迹文件，registry为nil时使用全局编解码注册表
+func CreateTraceFile(path string, registry *CodecRegistry) (*TraceFile, error) {
+	if registry == nil {
+		registry = GetCodecRegistry()
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &TraceFile{file: f, registry: registry}, nil
+}
+
+// 记录，每条记录直接写入文件，进程崩溃时已记录的都能读回，写入失败后不再记录，错误由Close返回
+// 类型没有注册的数据只记录文本，读回后ArgsLost为true，函数执行消息的函数不记录
+func (t *TraceFile) Record(e *TraceEvent) {
+	r := &traceRecord{
+		Time:    e.Time.UnixNano(),
+		Service: e.Service,
+		MsgType: e.MsgType,
+		MsgId:   e.MsgId,
+		FromKey: e.FromKey,
+		ToKey:   e.ToKey,
+		ReqId:   e.ReqId,
+		Headers: e.Headers,
+	}
+	if _, o := e.Args.(func()); !o {
+		var err error
+		r.ArgsType, r.Args, err = t.registry.Encode(e.Args)
+		if err != nil {
+			r.ArgsText = fmt.Sprint(e.Args)
+		}
+	}
+	data, err := GobCodec{}.Marshal(r)
+	if err != nil {
+		// key的类型没有注册到gob，改成文本
+		r.FromKey, r.ToKey = keyText(e.FromKey), keyText(e.ToKey)
+		if data, err = (GobCodec{}).Marshal(r); err != nil {
+			return
+		}
+	}
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	if t.err != nil {
+		return
+	}
+	t.seq += 1
+	_, t.err = t.file.Write(encodeJournalRecord(JournalRecord{Seq: t.seq, Data: data}))
+}
+
+// 关闭
+func (t *TraceFile) Close() error {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	if err := t.file.Close(); err != nil && t.err == nil {
+		t.err = err
+	}
+	return t.err
+}
+
+// key转成文本，nil保持不变
+func keyText(key interface{}) interface{} {
+	if key == nil {
+		return nil
+	}
+	return fmt.Sprint(key)
+}
+
+// 读取轨迹文件，registry为nil时使用全局编解码注册表
+func ReadTraceFile(path string, registry *CodecRegistry) ([]*TraceEvent, error) {
+	if registry == nil {
+		registry = GetCodecRegistry()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	reader := bytes.NewReader(data)
+	var events []*TraceEvent
+	for reader.Len() > 0 {
+		record, err := decodeJournalRecord(reader)
+		if err != nil {
+			// 进程崩溃时可能留下不完整的尾部记录
+			break
+		}
+		var r traceRecord
+		if err := (GobCodec{}).Unmarshal(record.Data, &r); err != nil {
+			return events, err
+		}
+		args, err := registry.Decode(r.ArgsType, r.Args)
+		events = append(events, &TraceEvent{
+			Time:     time.Unix(0, r.Time),
+			Service:  r.Service,
+			MsgType:  r.MsgType,
+			MsgId:    r.MsgId,
+			FromKey:  r.FromKey,
+			ToKey:    r.ToKey,
+			ReqId:    r.ReqId,
+			Args:     args,
+			ArgsLost: err != nil || r.ArgsText != "",
+			ArgsText: r.ArgsText,
+			Headers:  r.Headers,
+		})
+	}
+	return events, nil
+}
+
+// 内存记录器，一般用于测试
+type MemoryRecorder struct {
+	locker sync.Mutex
+	events []*TraceEvent
+}
+
+// 记录
+func (r *MemoryRecorder) Record(e *TraceEvent) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.events = append(r.events, e)
+}
+
+// 记录的事件
+func (r *MemoryRecorder) Events() []*TraceEvent {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	return append([]*TraceEvent(nil), r.events...)
+}
+
+// 把轨迹中service的消息按顺序交给新的服务处理，重现状态和处理函数的调用
+// 原来的sender已经不存在，重放时的返回、转发和通知都被丢弃
+// 函数执行消息(比如Go的结果、流的额度)没有记录函数，数据没有记录下来的消息不能用nil代替，都跳过
+// 返回重放和跳过的消息数，跳过的消息可能让重放的状态和原来不同
+func ReplayTrace(events []*TraceEvent, service string, target *LocalService) (replayed int, skipped int) {
+	for _, e := range events {
+		if e.Service != service {
+			continue
+		}
+		m, o := traceEventMsg(e)
+		if !o {
+			skipped += 1
+			continue
+		}
+		target.processMsg(m)
+		replayed += 1
+	}
+	return
+}
+
+// 把轨迹中返回处理器的返回按顺序交给新的返回处理器处理，返回重放和跳过的返回数
+func ReplayTraceResponses(events []*TraceEvent, name string, target *ResponseHandler) (replayed int, skipped int) {
+	for _, e := range events {
+		if e.Service != name {
+			continue
+		}
+		typ, _ := parseMsgType(e.MsgType)
+		if !typ.isResponse() {
+			continue
+		}
+		m, o := traceEventMsg(e)
+		if !o {
+			skipped += 1
+			continue
+		}
+		target.processResp(m)
+		replayed += 1
+	}
+	return
+}
+
+// 轨迹事件转成消息，函数执行消息和数据没有记录下来的不能重放
+func traceEventMsg(e *TraceEvent) (*msg, bool) {
+	typ, o := parseMsgType(e.MsgType)
+	if !o || typ == msgExec || e.ArgsLost {
+		return nil, false
+	}
+	m := getMsg()
+	m.typ = typ
+	m.id = e.MsgId
+	m.fromKey = e.FromKey
+	m.toKey = e.ToKey
+	m.reqId = e.ReqId
+	m.args = e.Args
+	m.headers = e.Headers
+	m.sender = recoveredSender{}
+	return m, true
+}
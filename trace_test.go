@@ -0,0 +1,146 @@
+package gproc
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+type buyItemReq struct {
+	ItemId int32
+	Num    int32
+}
+
+// 记录商店服务和客户端的消息，重放到新的服务和客户端，得到同样的状态和回调
+func TestTraceRecordAndReplay(t *testing.T) {
+	registry := NewCodecRegistry(nil)
+	registry.Register(1, "BuyItemReq", &buyItemReq{})
+	registry.Register(2, "ItemNum", int32(0))
+
+	newShop := func(items map[int32]int32) *LocalService {
+		shop := NewDefaultLocalService()
+		shop.SetName("shop")
+		shop.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+			req := args.(*buyItemReq)
+			items[req.ItemId] += req.Num
+			sender.Send(MsgIdBuyItem, items[req.ItemId])
+		})
+		return shop
+	}
+
+	path := filepath.Join(t.TempDir(), "shop.trace")
+	trace, err := CreateTraceFile(path, registry)
+	if err != nil {
+		t.Fatalf("create trace file err: %v", err)
+	}
+	items := make(map[int32]int32)
+	shop := newShop(items)
+	shop.SetRecorder(trace)
+	go shop.Run()
+
+	client := NewDefaultResponseHandler()
+	client.SetName("client")
+	client.SetRecorder(trace)
+	r := client.CreateRequester(shop, int32(1001))
+	var replies []interface{}
+	r.RegisterCallback(MsgIdBuyItem, func(args interface{}) {
+		replies = append(replies, args)
+	})
+	for _, req := range []*buyItemReq{{ItemId: 1, Num: 2}, {ItemId: 2, Num: 1}, {ItemId: 1, Num: 5}} {
+		r.Request(MsgIdBuyItem, req)
+	}
+	waitUntil(t, func() bool { return len(replies) == 3 }, client)
+	shop.Close()
+	<-shop.Done()
+	client.Close()
+	if err := trace.Close(); err != nil {
+		t.Fatalf("close trace err: %v", err)
+	}
+
+	events, err := ReadTraceFile(path, registry)
+	if err != nil {
+		t.Fatalf("read trace err: %v", err)
+	}
+	// 报名、3个请求和3个返回
+	if len(events) != 7 {
+		t.Fatalf("expect 7 events, got %v", len(events))
+	}
+	if e := events[0]; e.Service != "shop" || e.MsgType != "signup" || e.FromKey != int32(1001) {
+		t.Fatalf("unexpected first event %+v", e)
+	}
+
+	replayedItems := make(map[int32]int32)
+	if n, skipped := ReplayTrace(events, "shop", newShop(replayedItems)); n != 4 || skipped != 0 {
+		t.Fatalf("expect 4 replayed, got %v skipped %v", n, skipped)
+	}
+	if len(replayedItems) != 2 || replayedItems[1] != 7 || replayedItems[2] != 1 {
+		t.Fatalf("unexpected replayed state %v", replayedItems)
+	}
+
+	replayedClient := NewDefaultResponseHandler()
+	rr := replayedClient.CreateRequester(NewDefaultLocalService(), int32(1001))
+	var replayedReplies []interface{}
+	rr.RegisterCallback(MsgIdBuyItem, func(args interface{}) {
+		replayedReplies = append(replayedReplies, args)
+	})
+	ReplayTraceResponses(events, "client", replayedClient)
+	if len(replayedReplies) != 3 || replayedReplies[0] != int32(2) || replayedReplies[2] != int32(7) {
+		t.Fatalf("unexpected replayed replies %v", replayedReplies)
+	}
+}
+
+type unregisteredReq struct {
+	ItemId int32
+}
+
+// 类型没有注册的数据只记录文本，重放时跳过，不会用nil调用处理函数
+func TestTraceReplaySkipLostArgs(t *testing.T) {
+	registry := NewCodecRegistry(nil)
+	registry.Register(1, "BuyItemReq", &buyItemReq{})
+	path := filepath.Join(t.TempDir(), "shop.trace")
+	trace, _ := CreateTraceFile(path, registry)
+	trace.Record(&TraceEvent{Service: "shop", MsgType: "normal", MsgId: MsgIdBuyItem, Args: &buyItemReq{ItemId: 1, Num: 2}})
+	trace.Record(&TraceEvent{Service: "shop", MsgType: "normal", MsgId: MsgIdBuyItem, Args: &unregisteredReq{ItemId: 2}})
+	trace.Record(&TraceEvent{Service: "shop", MsgType: "normal", MsgId: MsgIdGetItemList})
+	if err := trace.Close(); err != nil {
+		t.Fatalf("close trace err: %v", err)
+	}
+	events, err := ReadTraceFile(path, registry)
+	if err != nil || len(events) != 3 {
+		t.Fatalf("read trace %v %v", len(events), err)
+	}
+	if !events[1].ArgsLost || events[1].ArgsText == "" || events[2].ArgsLost {
+		t.Fatalf("unexpected lost args %+v %+v", events[1], events[2])
+	}
+
+	shop := NewDefaultLocalService()
+	var bought []interface{}
+	listed := 0
+	shop.RegisterHandle(MsgIdBuyItem, func(sender ISender, args interface{}) {
+		bought = append(bought, args)
+	})
+	// 没有数据的请求照常重放
+	shop.RegisterHandle(MsgIdGetItemList, func(sender ISender, args interface{}) {
+		listed += 1
+	})
+	n, skipped := ReplayTrace(events, "shop", shop)
+	if n != 2 || skipped != 1 || len(bought) != 1 || bought[0] == nil || listed != 1 {
+		t.Fatalf("unexpected replay %v %v %v %v", n, skipped, bought, listed)
+	}
+}
+
+func TestTraceRecordWithoutClose(t *testing.T) {
+	registry := NewCodecRegistry(nil)
+	registry.Register(1, "BuyItemReq", &buyItemReq{})
+	path := filepath.Join(t.TempDir(), "shop.trace")
+	trace, err := CreateTraceFile(path, registry)
+	if err != nil {
+		t.Fatalf("create trace err: %v", err)
+	}
+	defer trace.Close()
+	// 没有关闭(比如进程崩溃)时已记录的也能读回
+	trace.Record(&TraceEvent{Service: "shop", MsgType: "normal", MsgId: MsgIdBuyItem, Args: &buyItemReq{ItemId: 1, Num: 2}})
+	events, err := ReadTraceFile(path, registry)
+	if err != nil || len(events) != 1 || events[0].MsgId != MsgIdBuyItem {
+		t.Fatalf("read unclosed trace %v %v", len(events), err)
+	}
+}
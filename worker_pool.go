@@ -0,0 +1,203 @@
+package gproc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	WorkerPoolSize        = 32                                   // 默认的worker数量
+	WorkerPoolQueueLength = 1024                                 // 默认的任务队列长度
+	WorkerPoolPostRetry   = time.Duration(10 * time.Millisecond) // 服务邮箱满时重新投递完成结果的间隔
+)
+
+// 执行阻塞任务的worker池，任务队列满时提交直接返回ErrWorkerPoolFull，不阻塞提交者
+type WorkerPool struct {
+	tasks     chan func()
+	chClose   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// 创建worker池
+func NewWorkerPool(workerNum int, queueLen int) *WorkerPool {
+	if workerNum <= 0 {
+		workerNum = WorkerPoolSize
+	}
+	if queueLen <= 0 {
+		queueLen = WorkerPoolQueueLength
+	}
+	p := &WorkerPool{
+		tasks:   make(chan func(), queueLen),
+		chClose: make(chan struct{}),
+	}
+	p.wg.Add(workerNum)
+	for i := 0; i < workerNum; i++ {
+		go p.run()
+	}
+	return p
+}
+
+// 提交任务
+func (p *WorkerPool) Submit(task func()) error {
+	select {
+	case <-p.chClose:
+		return ErrClosed
+	default:
+	}
+	select {
+	case p.tasks <- task:
+		return nil
+	default:
+		return ErrWorkerPoolFull
+	}
+}
+
+// 关闭，等待正在执行的任务完成，队列中的任务不再执行
+func (p *WorkerPool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.chClose)
+	})
+	p.wg.Wait()
+}
+
+// worker循环
+func (p *WorkerPool) run() {
+	defer p.wg.Done()
+	for {
+		select {
+		case task := <-p.tasks:
+			task()
+		case <-p.chClose:
+			return
+		}
+	}
+}
+
+var (
+	workerPool     atomic.Value
+	workerPoolOnce sync.Once
+)
+
+// 获取全局worker池，第一次使用时创建
+func GetWorkerPool() *WorkerPool {
+	workerPoolOnce.Do(func() {
+		if workerPool.Load() == nil {
+			workerPool.Store(NewWorkerPool(WorkerPoolSize, WorkerPoolQueueLength))
+		}
+	})
+	return workerPool.Load().(*WorkerPool)
+}
+
+// 替换全局worker池
+func SetWorkerPool(p *WorkerPool) {
+	workerPoolOnce.Do(func() {})
+	workerPool.Store(p)
+}
+
+// 在worker池中执行work，结果作为消息投递回服务的邮箱，onDone在服务的goroutine中调用
+// 服务关闭后还没开始的work不再执行，已完成的结果丢弃，onDone不会被调用
+func (s *LocalService) Go(work func() (interface{}, error), onDone func(interface{}, error)) error {
+	return s.GoContext(func(context.Context) (interface{}, error) {
+		return work()
+	}, onDone)
+}
+
+// 同Go，work带的ctx在服务关闭时取消，阻塞的work可以用它提前结束
+func (s *LocalService) GoContext(work func(ctx context.Context) (interface{}, error), onDone func(interface{}, error)) error {
+	if s.handler.IsClosed() {
+		return ErrClosed
+	}
+	pool := s.workerPool
+	if pool == nil {
+		pool = GetWorkerPool()
+	}
+	ctx := s.workContext()
+	return pool.Submit(func() {
+		if s.handler.IsClosed() {
+			return
+		}
+		result, err := runWork(ctx, work)
+		if s.handler.IsClosed() {
+			return
+		}
+		s.postWorkDone(func() {
+			if onDone != nil {
+				onDone(result, err)
+			}
+		})
+	})
+}
+
+// 完成的结果按完成顺序放入服务的结果队列，队列不空时只有一条取结果的执行消息在投递中
+func (s *LocalService) postWorkDone(done func()) {
+	s.workLocker.Lock()
+	s.workDone = append(s.workDone, done)
+	posting := s.workPosting
+	s.workPosting = true
+	s.workLocker.Unlock()
+	if posting {
+		return
+	}
+	m := getMsg()
+	m.typ = msgExec
+	m.args = s.runWorkDone
+	// 邮箱满时不阻塞worker，由一个goroutine定时重试，直到投递成功或者服务关闭
+	err := s.handler.offer(m)
+	if err == ErrMailboxFull {
+		go func() {
+			for err == ErrMailboxFull {
+				time.Sleep(WorkerPoolPostRetry)
+				err = s.handler.offer(m)
+			}
+			if err != nil {
+				putMsg(m)
+			}
+		}()
+	} else if err != nil {
+		putMsg(m)
+	}
+}
+
+// 在服务的goroutine中按完成顺序调用结果队列中的onDone
+func (s *LocalService) runWorkDone() {
+	s.workLocker.Lock()
+	done := s.workDone
+	s.workDone = nil
+	s.workPosting = false
+	s.workLocker.Unlock()
+	for _, f := range done {
+		f()
+	}
+}
+
+// 服务关闭时取消的ctx，第一次使用时创建
+func (s *LocalService) workContext() context.Context {
+	s.workOnce.Do(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.workCtx = ctx
+		go func() {
+			<-s.handler.chClose
+			cancel()
+		}()
+	})
+	return s.workCtx
+}
+
+// 设置服务使用的worker池，不设置时使用全局worker池
+func (s *LocalService) SetWorkerPool(pool *WorkerPool) {
+	s.workerPool = pool
+}
+
+// 执行work，panic转成错误
+func runWork(ctx context.Context, work func(context.Context) (interface{}, error)) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("gproc: work panic: %v", r)
+		}
+	}()
+	return work(ctx)
+}
@@ -0,0 +1,193 @@
+package gproc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 阻塞的查询在worker池中执行，结果回到服务的goroutine中返回
+func TestLocalServiceGo(t *testing.T) {
+	pool := NewWorkerPool(4, 16)
+	defer pool.Close()
+
+	service := NewDefaultLocalService()
+	service.SetWorkerPool(pool)
+	// 只在服务的goroutine中访问，-race下可以检查onDone的执行goroutine
+	pending := 0
+	service.RegisterHandle(MsgIdGetItemList, func(sender ISender, args interface{}) {
+		pending += 1
+		err := service.Go(func() (interface{}, error) {
+			time.Sleep(time.Millisecond * 10)
+			if args == "panic" {
+				panic("db down")
+			}
+			return args.(int) * 2, nil
+		}, func(result interface{}, err error) {
+			pending -= 1
+			if err != nil {
+				sender.Send(MsgIdGetItemList, err)
+			} else {
+				sender.Send(MsgIdGetItemList, result)
+			}
+		})
+		if err != nil {
+			t.Errorf("go err: %v", err)
+		}
+	})
+	go service.Run()
+	defer service.Close()
+
+	p := NewDefaultResponseHandler()
+	defer p.Close()
+	r := p.CreateRequester(service, "player")
+	var results []interface{}
+	r.RegisterCallback(MsgIdGetItemList, func(args interface{}) {
+		results = append(results, args)
+	})
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		r.Request(MsgIdGetItemList, i)
+	}
+	r.Request(MsgIdGetItemList, "panic")
+	waitUntil(t, func() bool { return len(results) == 5 }, p)
+	// 4个worker并行执行，不会串行阻塞服务
+	if time.Since(start) > time.Millisecond*200 {
+		t.Fatalf("work not executed concurrently: %v", time.Since(start))
+	}
+	sum, panics := 0, 0
+	for _, res := range results {
+		switch v := res.(type) {
+		case int:
+			sum += v
+		case error:
+			panics += 1
+		}
+	}
+	if sum != 12 || panics != 1 {
+		t.Fatalf("unexpected results %v", results)
+	}
+}
+
+// 服务关闭后排队的work不再执行，完成的结果不再投递
+func TestLocalServiceGoCancel(t *testing.T) {
+	pool := NewWorkerPool(1, 1)
+	defer pool.Close()
+
+	service := NewDefaultLocalService()
+	service.SetWorkerPool(pool)
+	go service.Run()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var executed, done int32
+	service.Go(func() (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	}, func(interface{}, error) {
+		atomic.AddInt32(&done, 1)
+	})
+	<-started
+	if err := service.Go(func() (interface{}, error) {
+		atomic.AddInt32(&executed, 1)
+		return nil, nil
+	}, nil); err != nil {
+		t.Fatalf("go err: %v", err)
+	}
+	if err := service.Go(func() (interface{}, error) { return nil, nil }, nil); err != ErrWorkerPoolFull {
+		t.Fatalf("expect ErrWorkerPoolFull, got %v", err)
+	}
+
+	service.Close()
+	<-service.Done()
+	close(release)
+	time.Sleep(time.Millisecond * 20)
+	if n := atomic.LoadInt32(&executed); n != 0 {
+		t.Fatalf("queued work executed after close")
+	}
+	if n := atomic.LoadInt32(&done); n != 0 {
+		t.Fatalf("onDone called after close")
+	}
+	if err := service.Go(func() (interface{}, error) { return nil, nil }, nil); err != ErrClosed {
+		t.Fatalf("expect ErrClosed, got %v", err)
+	}
+}
+
+// 服务关闭时取消work的ctx
+func TestLocalServiceGoContext(t *testing.T) {
+	pool := NewWorkerPool(1, 1)
+	defer pool.Close()
+
+	service := NewDefaultLocalService()
+	service.SetWorkerPool(pool)
+	go service.Run()
+
+	started := make(chan struct{})
+	cancelled := make(chan error, 1)
+	service.GoContext(func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return nil, ctx.Err()
+	}, nil)
+	<-started
+	service.Close()
+	select {
+	case err := <-cancelled:
+		if err != context.Canceled {
+			t.Fatalf("expect context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("work ctx not cancelled on close")
+	}
+}
+
+// 服务邮箱满时完成的结果不占用worker，邮箱空出来后按完成顺序回到服务
+func TestLocalServiceGoMailboxFull(t *testing.T) {
+	pool := NewWorkerPool(1, 4)
+	defer pool.Close()
+
+	service := NewLocalService(1)
+	service.SetWorkerPool(pool)
+	// 逐步执行时投递结果也不能panic
+	service.SetStepping(true)
+	defer service.Close()
+	// 服务还没运行，占满邮箱
+	service.handler.Send(getMsg())
+
+	var executed int32
+	var done []int
+	for i := 0; i < 3; i++ {
+		i := i
+		if err := service.Go(func() (interface{}, error) {
+			atomic.AddInt32(&executed, 1)
+			return i, nil
+		}, func(result interface{}, err error) {
+			done = append(done, result.(int))
+		}); err != nil {
+			t.Fatalf("go err: %v", err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&executed) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("worker blocked on full mailbox, executed %v", atomic.LoadInt32(&executed))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	deadline = time.Now().Add(time.Second * 2)
+	for len(done) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect 3 done, got %v", done)
+		}
+		service.Step()
+	}
+	for i, result := range done {
+		if result != i {
+			t.Fatalf("onDone out of order: %v", done)
+		}
+	}
+}